@@ -0,0 +1,92 @@
+package jenkins
+
+import (
+	"fmt"
+	"strings"
+)
+
+type BuildCause struct {
+	Class            string `json:"_class"`
+	ShortDescription string `json:"shortDescription"`
+	UpstreamProject  string `json:"upstreamProject"`
+	UpstreamBuild    int    `json:"upstreamBuild"`
+	UserId           string `json:"userId"`
+}
+
+// Kind is timer, scm, upstream, user or other
+func (c BuildCause) Kind() string {
+	class := c.Class[strings.LastIndexAny(c.Class, ".$")+1:]
+	switch {
+	case strings.Contains(class, "Timer"):
+		return "timer"
+	case strings.Contains(class, "SCM"):
+		return "scm"
+	case strings.Contains(class, "Upstream"):
+		return "upstream"
+	case strings.Contains(class, "User"):
+		return "user"
+	}
+	return "other"
+}
+
+// BuildParameter values are strings, booleans or numbers depending on the
+// parameter type, password parameters have none
+type BuildParameter struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+type User struct {
+	FullName string `json:"fullName"`
+}
+
+type Change struct {
+	CommitId string `json:"commitId"`
+	Msg      string `json:"msg"`
+	Author   User   `json:"author"`
+}
+
+type ChangeSet struct {
+	Items []Change `json:"items"`
+}
+
+func (b BuildInfo) Causes() []BuildCause {
+	var causes []BuildCause
+	for _, a := range b.Actions {
+		causes = append(causes, a.Causes...)
+	}
+	return causes
+}
+
+// Parameters returns the parameter values as text
+func (b BuildInfo) Parameters() map[string]string {
+	params := make(map[string]string)
+	for _, a := range b.Actions {
+		for _, p := range a.Parameters {
+			if p.Value == nil {
+				params[p.Name] = ""
+			} else {
+				params[p.Name] = fmt.Sprint(p.Value)
+			}
+		}
+	}
+	return params
+}
+
+// Upstream is the job and build that triggered this one, if any
+func (b BuildInfo) Upstream() (string, int) {
+	for _, c := range b.Causes() {
+		if c.UpstreamProject != "" {
+			return c.UpstreamProject, c.UpstreamBuild
+		}
+	}
+	return "", 0
+}
+
+func (b BuildInfo) Changes() []Change {
+	changes := append([]Change(nil), b.ChangeSet.Items...)
+	for _, set := range b.ChangeSets {
+		changes = append(changes, set.Items...)
+	}
+	return changes
+}
@@ -0,0 +1,58 @@
+package jenkins
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestBuildInfoMetadata(t *testing.T) {
+	data, err := os.ReadFile("buildinfo_test.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var info BuildInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		t.Fatal(err.Error())
+	}
+	if info.BuiltOn != "euca-jdk-1-6-linux-2-6-113" {
+		t.Fatalf("Unexpected node %s", info.BuiltOn)
+	}
+	if !info.Pipeline() || (BuildInfo{Class: "hudson.model.FreeStyleBuild"}).Pipeline() {
+		t.Fatalf("Expected only the WorkflowRun to be a pipeline")
+	}
+	if failed, total := info.Tests(); failed != 3 || total != 412 {
+		t.Fatalf("Expected 3 of 412 tests to fail but got %d of %d", failed, total)
+	}
+	causes := info.Causes()
+	if len(causes) != 2 || causes[0].Kind() != "upstream" || causes[1].Kind() != "scm" {
+		t.Fatalf("Unexpected causes %+v", causes)
+	}
+	if job, number := info.Upstream(); job != "compile" || number != 455 {
+		t.Fatalf("Expected upstream compile #455 but got %s #%d", job, number)
+	}
+	params := info.Parameters()
+	if params["BRANCH"] != "release-2.1" || params["SKIP_SLOW"] != "true" || params["TOKEN"] != "" || len(params) != 3 {
+		t.Fatalf("Unexpected parameters %v", params)
+	}
+	changes := info.Changes()
+	if len(changes) != 2 || changes[1].CommitId != "98fa01d" || changes[1].Author.FullName != "Grace Hopper" {
+		t.Fatalf("Unexpected changes %+v", changes)
+	}
+	if len(info.Culprits) != 2 || info.Culprits[0].FullName != "Ada Lovelace" {
+		t.Fatalf("Unexpected culprits %+v", info.Culprits)
+	}
+}
+
+func TestBuildCauseKind(t *testing.T) {
+	kinds := map[string]string{
+		"hudson.triggers.TimerTrigger$TimerTriggerCause": "timer",
+		"hudson.model.Cause$UserIdCause":                 "user",
+		"jenkins.branch.BranchIndexingCause":             "other",
+	}
+	for class, kind := range kinds {
+		if (BuildCause{Class: class}).Kind() != kind {
+			t.Fatalf("Expected %s to be %s but got %s", class, kind, BuildCause{Class: class}.Kind())
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package jenkins
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// BuildRef points at a build of a job, either by number, by one of the
+// Jenkins permalinks (last, lastSuccessful, ...) or relative to the last
+// build. Job is the full folder path separated by '/'. Server is only set
+// when the reference was parsed from a full url.
+type BuildRef struct {
+	Server string
+	Job    string
+	Number int
+	Symbol string
+	Offset int
+}
+
+var permalinks = map[string]string{
+	"last":             "lastBuild",
+	"lastsuccessful":   "lastSuccessfulBuild",
+	"lastfailed":       "lastFailedBuild",
+	"laststable":       "lastStableBuild",
+	"lastunstable":     "lastUnstableBuild",
+	"lastunsuccessful": "lastUnsuccessfulBuild",
+	"lastcompleted":    "lastCompletedBuild",
+}
+
+func (r BuildRef) String() string {
+	var build string
+	if r.Number > 0 {
+		build = strconv.Itoa(r.Number)
+	} else if r.Offset < 0 {
+		build = strconv.Itoa(r.Offset)
+	} else if r.Symbol != "" {
+		build = strings.TrimSuffix(r.Symbol, "Build")
+	} else {
+		build = "last"
+	}
+	return r.Job + "#" + build
+}
+
+// ParseBuildRef accepts job#123, job#last, job#lastSuccessful, job#-2,
+// folder/job#123 and build urls as copied from the browser, with or
+// without user:password@ in front of the host.
+func ParseBuildRef(ref string) (BuildRef, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return BuildRef{}, errors.New("Empty build reference")
+	}
+	if strings.Contains(ref, "://") || strings.HasPrefix(ref, "/job/") {
+		return parseBuildUrl(ref)
+	}
+	job := ref
+	build := ""
+	if ind := strings.LastIndex(ref, "#"); ind != -1 {
+		job = ref[0:ind]
+		build = ref[ind+1:]
+	}
+	job = strings.Trim(job, "/")
+	if job == "" {
+		return BuildRef{}, errors.New("No job in build reference " + ref)
+	}
+	return parseBuildPart(BuildRef{Job: job}, build)
+}
+
+func parseBuildPart(ref BuildRef, build string) (BuildRef, error) {
+	if build == "" {
+		ref.Symbol = "lastBuild"
+		return ref, nil
+	}
+	if permalink, ok := permalinks[strings.TrimSuffix(strings.ToLower(build), "build")]; ok {
+		ref.Symbol = permalink
+		return ref, nil
+	}
+	n, err := strconv.Atoi(build)
+	if err != nil {
+		return BuildRef{}, errors.New("Invalid build " + build + " for " + ref.Job)
+	}
+	if n < 0 {
+		ref.Offset = n
+	} else if n > 0 {
+		ref.Number = n
+	} else {
+		return BuildRef{}, errors.New("Invalid build 0 for " + ref.Job)
+	}
+	return ref, nil
+}
+
+func parseBuildUrl(ref string) (BuildRef, error) {
+	if ind := strings.Index(ref, "#"); ind != -1 {
+		ref = ref[0:ind]
+	}
+	if ind := strings.Index(ref, "?"); ind != -1 {
+		ref = ref[0:ind]
+	}
+	ind := strings.Index(ref, "/job/")
+	if ind == -1 {
+		return BuildRef{}, errors.New("No job in build url " + ref)
+	}
+	result := BuildRef{Server: strings.TrimSuffix(ref[0:ind], "/")}
+	if ind = strings.Index(result.Server, "/view/"); ind != -1 {
+		result.Server = result.Server[0:ind]
+	}
+	parts := strings.Split(strings.Trim(ref[strings.Index(ref, "/job/"):], "/"), "/")
+	var jobs []string
+	build := ""
+	for i := 0; i < len(parts); i++ {
+		if parts[i] == "job" && i+1 < len(parts) {
+			name, err := url.PathUnescape(parts[i+1])
+			if err != nil {
+				return BuildRef{}, errors.New("Invalid job in build url " + ref)
+			}
+			jobs = append(jobs, name)
+			i++
+			continue
+		}
+		if parts[i] == "view" {
+			i++
+			continue
+		}
+		build = parts[i]
+		break
+	}
+	if len(jobs) == 0 {
+		return BuildRef{}, errors.New("No job in build url " + ref)
+	}
+	result.Job = strings.Join(jobs, "/")
+	return parseBuildPart(result, build)
+}
+
+// jobUrl expands a folder path to the nested /job/ form Jenkins expects.
+func jobUrl(base, job string) string {
+	parts := strings.Split(strings.Trim(job, "/"), "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return base + "/job/" + strings.Join(parts, "/job/")
+}
+
+// server returns the jenkins that a reference should be resolved against,
+// keeping the configured credentials when the url points at the same host.
+func (j jenkins) server(ref BuildRef) jenkins {
+	if ref.Server == "" {
+		return j
+	}
+	other := jenkins(ref.Server)
+	if other.url() == j.url() {
+		return j
+	}
+	return other
+}
+
+func (j jenkins) Resolve(ref BuildRef) (BuildRef, error) {
+	if ref.Number > 0 {
+		return ref, nil
+	}
+	s := j.server(ref)
+	if ref.Offset < 0 {
+		var builds struct {
+			Builds []struct {
+				Number int `json:"number"`
+			} `json:"builds"`
+		}
+		if err := s.getJson(jobUrl(s.url(), ref.Job)+"/api/json?tree=builds[number]", &builds); err != nil {
+			return BuildRef{}, err
+		}
+		if -ref.Offset >= len(builds.Builds) {
+			return BuildRef{}, errors.New("Only " + strconv.Itoa(len(builds.Builds)) + " builds for " + ref.Job)
+		}
+		ref.Number = builds.Builds[-ref.Offset].Number
+		return ref, nil
+	}
+	symbol := ref.Symbol
+	if symbol == "" {
+		symbol = "lastBuild"
+	}
+	var build struct {
+		Number int `json:"number"`
+	}
+	if err := s.getJson(jobUrl(s.url(), ref.Job)+"/"+symbol+"/api/json?tree=number", &build); err != nil {
+		return BuildRef{}, errors.New("Could not resolve " + ref.String() + ": " + err.Error())
+	}
+	ref.Number = build.Number
+	return ref, nil
+}
+
+func (j jenkins) BuildInfo(ref BuildRef) (BuildInfo, error) {
+	ref, err := j.Resolve(ref)
+	if err != nil {
+		return BuildInfo{}, err
+	}
+	s := j.server(ref)
+	info := BuildInfo{Job: ref.Job}
+	err = s.getJson(jobUrl(s.url(), ref.Job)+"/"+strconv.Itoa(ref.Number)+"/api/json?tree="+buildInfoTree, &info)
+	if err != nil {
+		return BuildInfo{}, err
+	}
+	return info, nil
+}
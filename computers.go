@@ -0,0 +1,148 @@
+package jenkins
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+type Computer struct {
+	Name          string
+	Offline       bool
+	OfflineReason string
+	Labels        []string
+	Executors     []Executor
+}
+
+type Executor struct {
+	Number   int
+	Build    string
+	Url      string
+	Started  time.Time
+	Progress int
+}
+
+func (e Executor) Idle() bool {
+	return e.Build == ""
+}
+
+type QueueItem struct {
+	Id      int
+	Job     string
+	Url     string
+	Why     string
+	Blocked bool
+	Stuck   bool
+	Since   time.Time
+}
+
+type executableJson struct {
+	FullDisplayName string `json:"fullDisplayName"`
+	Url             string `json:"url"`
+	Timestamp       int64  `json:"timestamp"`
+}
+
+type computersJson struct {
+	Computer []struct {
+		DisplayName        string `json:"displayName"`
+		Offline            bool   `json:"offline"`
+		OfflineCauseReason string `json:"offlineCauseReason"`
+		AssignedLabels     []struct {
+			Name string `json:"name"`
+		} `json:"assignedLabels"`
+		Executors []struct {
+			Number            int             `json:"number"`
+			Progress          int             `json:"progress"`
+			CurrentExecutable *executableJson `json:"currentExecutable"`
+		} `json:"executors"`
+	} `json:"computer"`
+}
+
+type queueJson struct {
+	Items []struct {
+		Id           int    `json:"id"`
+		Why          string `json:"why"`
+		Blocked      bool   `json:"blocked"`
+		Stuck        bool   `json:"stuck"`
+		InQueueSince int64  `json:"inQueueSince"`
+		Task         struct {
+			Name string `json:"name"`
+			Url  string `json:"url"`
+		} `json:"task"`
+	} `json:"items"`
+}
+
+func millis(ms int64) time.Time {
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond))
+}
+
+func parseComputers(rdr io.Reader) ([]Computer, error) {
+	var cj computersJson
+	if err := json.NewDecoder(rdr).Decode(&cj); err != nil {
+		return nil, err
+	}
+	var computers []Computer
+	for _, c := range cj.Computer {
+		computer := Computer{Name: c.DisplayName, Offline: c.Offline, OfflineReason: c.OfflineCauseReason}
+		for _, label := range c.AssignedLabels {
+			if label.Name != c.DisplayName {
+				computer.Labels = append(computer.Labels, label.Name)
+			}
+		}
+		for _, e := range c.Executors {
+			executor := Executor{Number: e.Number, Progress: e.Progress}
+			if e.CurrentExecutable != nil {
+				executor.Build = e.CurrentExecutable.FullDisplayName
+				executor.Url = e.CurrentExecutable.Url
+				executor.Started = millis(e.CurrentExecutable.Timestamp)
+			}
+			computer.Executors = append(computer.Executors, executor)
+		}
+		computers = append(computers, computer)
+	}
+	return computers, nil
+}
+
+func parseQueue(rdr io.Reader) ([]QueueItem, error) {
+	var qj queueJson
+	if err := json.NewDecoder(rdr).Decode(&qj); err != nil {
+		return nil, err
+	}
+	var items []QueueItem
+	for _, i := range qj.Items {
+		items = append(items, QueueItem{i.Id, i.Task.Name, i.Task.Url, i.Why, i.Blocked, i.Stuck, millis(i.InQueueSince)})
+	}
+	return items, nil
+}
+
+func (j jenkins) Computers() ([]Computer, error) {
+	body, err := j.get(j.url() + "/computer/api/json?tree=computer[displayName,offline,offlineCauseReason," +
+		"assignedLabels[name],executors[number,progress,currentExecutable[fullDisplayName,url,timestamp]]]")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return parseComputers(body)
+}
+
+func (j jenkins) Queue() ([]QueueItem, error) {
+	body, err := j.get(j.url() + "/queue/api/json?tree=items[id,why,blocked,stuck,inQueueSince,task[name,url]]")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return parseQueue(body)
+}
+
+func (j jenkins) Console(ref BuildRef) (io.ReadCloser, error) {
+	ref, err := j.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	s := j.server(ref)
+	return s.get(jobUrl(s.url(), ref.Job) + "/" + strconv.Itoa(ref.Number) + "/consoleText")
+}
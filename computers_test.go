@@ -0,0 +1,55 @@
+package jenkins
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseComputers(t *testing.T) {
+	f, err := os.Open("computers_test.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer f.Close()
+	computers, err := parseComputers(f)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(computers) != 3 {
+		t.Fatalf("Expected 3 computers but got %d", len(computers))
+	}
+	if len(computers[0].Executors) != 2 || !computers[0].Executors[1].Idle() {
+		t.Fatalf("Expected two idle executors on master but got %+v", computers[0])
+	}
+	busy := computers[1].Executors[0]
+	if busy.Build != "T2-Extra_Minutely #454" || busy.Started.Unix() != 1381912345 || busy.Progress != 46 {
+		t.Fatalf("Unexpected executor %+v", busy)
+	}
+	if len(computers[1].Labels) != 2 || computers[1].Labels[0] != "jdk-1.6" {
+		t.Fatalf("Unexpected labels %v", computers[1].Labels)
+	}
+	if !computers[2].Offline || computers[2].OfflineReason != "Disconnected by admin" {
+		t.Fatalf("Expected offline computer but got %+v", computers[2])
+	}
+}
+
+func TestParseQueue(t *testing.T) {
+	f, err := os.Open("queue_test.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer f.Close()
+	items, err := parseQueue(f)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 queue items but got %d", len(items))
+	}
+	if items[0].Job != "VOID_Minutely" || !items[0].Stuck || items[0].Blocked {
+		t.Fatalf("Unexpected item %+v", items[0])
+	}
+	if !items[1].Blocked || items[1].Why != "Build #455 is already in progress (ETA:14 min)" {
+		t.Fatalf("Unexpected item %+v", items[1])
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 func parseGetChild(node *html.Node, childType atom.Atom, count int) (*html.Node, error) {
@@ -34,6 +36,35 @@ func parseGetChild(node *html.Node, childType atom.Atom, count int) (*html.Node,
 	return nil, errors.New("Atom not found " + childType.String() + " in " + h5.NewTree(node).String())
 }
 
+// parseOffline looks for the "(offline)" text jenkins puts after the node link
+func parseOffline(link *html.Node) bool {
+	for n := link.NextSibling; n != nil; n = n.NextSibling {
+		if n.Type == html.TextNode && strings.Contains(n.Data, "(offline)") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBuildLink returns the number and the link of the #123 anchor
+func parseBuildLink(div *html.Node) (int, string) {
+	link, err := parseGetChild(div, atom.A, 2)
+	if err != nil || link.FirstChild == nil {
+		return 0, ""
+	}
+	href := ""
+	for _, attr := range link.Attr {
+		if attr.Key == "href" {
+			href = attr.Val
+		}
+	}
+	number, err := strconv.Atoi(strings.TrimPrefix(link.FirstChild.Data, "#"))
+	if err != nil {
+		return 0, href
+	}
+	return number, href
+}
+
 func parsePrint(n *html.Node) {
 	fmt.Println(h5.NewTree(n).String())
 }
@@ -68,15 +99,16 @@ func parseExecutors(rdr io.Reader) ([]Build, error) {
 				tr = tr.NextSibling
 				continue
 			}
+			offline := parseOffline(nameLink)
 			if tr.NextSibling == nil {
-				builds = append(builds, Build{nameLink.FirstChild.Data, ""})
+				builds = append(builds, Build{nameLink.FirstChild.Data, "", 0, offline, ""})
 				break
 			}
 			tr = tr.NextSibling
 			_, err = parseGetChild(tr, atom.Th, 1)
 			if err == nil {
 				// no data row
-				builds = append(builds, Build{nameLink.FirstChild.Data, ""})
+				builds = append(builds, Build{nameLink.FirstChild.Data, "", 0, offline, ""})
 				continue
 			}
 			if tr.FirstChild == nil || tr.FirstChild.NextSibling == nil {
@@ -89,13 +121,14 @@ func parseExecutors(rdr io.Reader) ([]Build, error) {
 			buildDiv, err := parseGetChild(buildTd, atom.Div, 1)
 			if err != nil {
 				// empty data row
-				builds = append(builds, Build{nameLink.FirstChild.Data, ""})
+				builds = append(builds, Build{nameLink.FirstChild.Data, "", 0, offline, ""})
 			} else {
 				build, err := parseGetChild(buildDiv, atom.A, 1)
 				if err != nil {
 					return nil, err
 				}
-				builds = append(builds, Build{nameLink.FirstChild.Data, build.FirstChild.Data})
+				number, href := parseBuildLink(buildDiv)
+				builds = append(builds, Build{nameLink.FirstChild.Data, build.FirstChild.Data, number, offline, href})
 			}
 		}
 		if tr == tbody.LastChild {
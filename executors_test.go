@@ -7,7 +7,7 @@ import (
 
 func checkBuild(t *testing.T, node, build string, actual Build) {
 	if actual.Node != node || actual.Build != build {
-		t.Fatal("Expected " + Build{node, build}.String() + " but got " + actual.String())
+		t.Fatal("Expected " + Build{Node: node, Build: build}.String() + " but got " + actual.String())
 	}
 }
 
@@ -27,4 +27,19 @@ func TestParseExecutors(t *testing.T) {
 	}
 	checkBuild(t, "dumslav", "", executors[0])
 	checkBuild(t, "euca-jdk-1-6-linux-2-6-782", "VOID_Minutely", executors[2])
+	if executors[2].Number != 1045 {
+		t.Fatalf("Expected build number 1045 but got %d", executors[2].Number)
+	}
+	if executors[2].Url != "/jenkins/job/VOID_Minutely/1045/" {
+		t.Fatalf("Expected the build link but got %q", executors[2].Url)
+	}
+	offline := 0
+	for _, executor := range executors {
+		if executor.Offline {
+			offline++
+		}
+	}
+	if offline != 4 {
+		t.Fatalf("Expected 4 offline nodes but got %d", offline)
+	}
 }
@@ -0,0 +1,127 @@
+package jenkins
+
+import (
+	"strconv"
+)
+
+// BuildNode is a build with the builds it triggered. Selected marks the
+// build the graph was asked for.
+type BuildNode struct {
+	Info       BuildInfo
+	Selected   bool
+	Downstream []BuildNode
+}
+
+const (
+	// graphDepth stops chains of upstream builds that never end
+	graphDepth = 20
+	// graphScan is how many recent builds of a downstream project are
+	// searched for builds started by the upstream build
+	graphScan = 25
+)
+
+// BuildGraph follows the upstream causes of ref to the build that started
+// the chain and returns the tree of builds triggered from there. Downstream
+// builds are found from the triggered builds of the parameterized trigger
+// plugin and by searching the recent builds of the downstream projects for
+// an upstream cause pointing back. The chain walked up from ref is always
+// part of the tree, however long ago it was built.
+func (j jenkins) BuildGraph(ref BuildRef) (BuildNode, error) {
+	s := j.server(ref)
+	info, err := s.BuildInfo(ref)
+	if err != nil {
+		return BuildNode{}, err
+	}
+	selected := buildKey(info.Job, info.Number)
+	chain := make(map[string]BuildRef)
+	for i := 0; i < graphDepth; i++ {
+		job, number := info.Upstream()
+		if job == "" {
+			break
+		}
+		upstream, err := s.BuildInfo(BuildRef{Job: job, Number: number})
+		if err != nil {
+			// the upstream build may have been deleted
+			break
+		}
+		chain[buildKey(upstream.Job, upstream.Number)] = BuildRef{Job: info.Job, Number: info.Number}
+		info = upstream
+	}
+	seen := make(map[string]bool)
+	return s.expand(info, selected, chain, seen, 0), nil
+}
+
+func buildKey(job string, number int) string {
+	return job + "#" + strconv.Itoa(number)
+}
+
+func (j jenkins) expand(info BuildInfo, selected string, chain map[string]BuildRef, seen map[string]bool, depth int) BuildNode {
+	key := buildKey(info.Job, info.Number)
+	seen[key] = true
+	node := BuildNode{Info: info, Selected: key == selected}
+	if depth >= graphDepth {
+		return node
+	}
+	refs := j.downstream(info)
+	if ref, ok := chain[key]; ok {
+		refs = append([]BuildRef{ref}, refs...)
+	}
+	for _, ref := range refs {
+		if seen[buildKey(ref.Job, ref.Number)] {
+			continue
+		}
+		s := j.server(ref)
+		downstream, err := s.BuildInfo(ref)
+		if err != nil {
+			continue
+		}
+		node.Downstream = append(node.Downstream, s.expand(downstream, selected, chain, seen, depth+1))
+	}
+	return node
+}
+
+// downstream lists the builds that info triggered, errors just leave
+// builds out of the graph
+func (j jenkins) downstream(info BuildInfo) []BuildRef {
+	var refs []BuildRef
+	found := make(map[string]bool)
+	add := func(ref BuildRef) {
+		if !found[buildKey(ref.Job, ref.Number)] {
+			found[buildKey(ref.Job, ref.Number)] = true
+			refs = append(refs, ref)
+		}
+	}
+	for _, a := range info.Actions {
+		for _, triggered := range a.TriggeredBuilds {
+			if ref, err := ParseBuildRef(triggered.Url); err == nil && ref.Number > 0 {
+				add(ref)
+			}
+		}
+	}
+	var job struct {
+		Downstream []struct {
+			FullName string `json:"fullName"`
+		} `json:"downstreamProjects"`
+	}
+	if err := j.getJson(jobUrl(j.url(), info.Job)+"/api/json?tree=downstreamProjects[fullName]", &job); err != nil {
+		return refs
+	}
+	for _, project := range job.Downstream {
+		var builds struct {
+			Builds []BuildInfo `json:"allBuilds"`
+		}
+		err := j.getJson(jobUrl(j.url(), project.FullName)+"/api/json?tree=allBuilds[number,actions[causes[upstreamProject,upstreamBuild]]]{0,"+
+			strconv.Itoa(graphScan)+"}", &builds)
+		if err != nil {
+			continue
+		}
+		for _, build := range builds.Builds {
+			for _, cause := range build.Causes() {
+				if cause.UpstreamProject == info.Job && cause.UpstreamBuild == info.Number {
+					add(BuildRef{Job: project.FullName, Number: build.Number})
+				}
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,67 @@
+package jenkins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBuildGraph(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/job/compile/455/api/json":
+			w.Write([]byte(`{"number":455,"result":"SUCCESS","builtOn":"agent1","duration":1000}`))
+		case "/job/compile/api/json":
+			w.Write([]byte(`{"downstreamProjects":[{"fullName":"integration"},{"fullName":"lint"},{"fullName":"docs"}]}`))
+		case "/job/integration/api/json":
+			if strings.Contains(r.URL.RawQuery, "allBuilds") {
+				// 812 has been pushed out of the recent builds
+				w.Write([]byte(`{"allBuilds":[{"number":813,"actions":[{"causes":[{"upstreamProject":"compile","upstreamBuild":456}]}]}]}`))
+			} else {
+				w.Write([]byte(`{"downstreamProjects":[]}`))
+			}
+		case "/job/integration/812/api/json":
+			w.Write([]byte(`{"number":812,"result":"FAILURE","builtOn":"agent7","duration":2000,"actions":[` +
+				`{"causes":[{"upstreamProject":"compile","upstreamBuild":455}]},` +
+				`{"triggeredBuilds":[{"url":"` + server.URL + `/job/folder/job/deploy/3/"}]}]}`))
+		case "/job/folder/job/deploy/3/api/json":
+			w.Write([]byte(`{"number":3,"building":true,"actions":[{"causes":[{"upstreamProject":"integration","upstreamBuild":812}]}]}`))
+		case "/job/folder/job/deploy/api/json":
+			w.Write([]byte(`{}`))
+		case "/job/lint/api/json":
+			if strings.Contains(r.URL.RawQuery, "allBuilds") {
+				w.Write([]byte(`{"allBuilds":[{"number":20,"actions":[{},{"causes":[{"upstreamProject":"compile","upstreamBuild":455}]}]}]}`))
+			} else {
+				w.Write([]byte(`{}`))
+			}
+		case "/job/lint/20/api/json":
+			w.Write([]byte(`{"number":20,"result":"SUCCESS","actions":[{"causes":[{"upstreamProject":"compile","upstreamBuild":455}]}]}`))
+		case "/job/docs/api/json":
+			http.Error(w, "broken", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	// the reference points at another server than the configured one
+	graph, err := jenkins("http://other/jenkins").BuildGraph(BuildRef{Server: server.URL, Job: "integration", Number: 812})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if graph.Info.Job != "compile" || graph.Info.Number != 455 || graph.Selected || len(graph.Downstream) != 2 {
+		t.Fatalf("Expected compile #455 to start the chain but got %+v", graph)
+	}
+	integration := graph.Downstream[0]
+	if integration.Info.Job != "integration" || !integration.Selected || integration.Info.BuiltOn != "agent7" || len(integration.Downstream) != 1 {
+		t.Fatalf("Unexpected integration build %+v", integration)
+	}
+	if lint := graph.Downstream[1].Info; lint.Job != "lint" || lint.Number != 20 {
+		t.Fatalf("Expected lint #20 to be found from the recent builds but got %+v", lint)
+	}
+	deploy := integration.Downstream[0].Info
+	if deploy.Job != "folder/deploy" || deploy.Number != 3 || !deploy.Building {
+		t.Fatalf("Unexpected deploy build %+v", deploy)
+	}
+}
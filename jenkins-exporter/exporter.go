@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jwiklund/jenkins"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds in seconds of the build duration
+// histograms
+var durationBuckets = []float64{60, 300, 600, 1200, 1800, 3600, 7200, 14400}
+
+// storedStats is what the exporter needs from the /api/stats aggregates
+// served by jenkins-nodelog -serve
+type storedStats struct {
+	Job     string  `json:"job"`
+	Host    string  `json:"host"`
+	Result  string  `json:"result"`
+	Builds  int     `json:"builds"`
+	Timed   int     `json:"timed"`
+	Seconds float64 `json:"seconds"`
+	Buckets []int   `json:"buckets"`
+}
+
+// exporter renders /metrics at most once per cache period however often
+// it is scraped, the nodelog stats are kept for storeCache since they
+// change slowly
+type exporter struct {
+	j          jenkins.Jenkins
+	nodelog    string
+	cache      time.Duration
+	storeCache time.Duration
+	client     *http.Client
+
+	mu      sync.Mutex
+	page    []byte
+	at      time.Time
+	stats   []storedStats
+	statsAt time.Time
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.page == nil || time.Since(e.at) >= e.cache {
+		var buf bytes.Buffer
+		e.collect().write(&buf)
+		e.page = buf.Bytes()
+		e.at = time.Now()
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(e.page)
+}
+
+func (e *exporter) collect() *metrics {
+	m := newMetrics()
+	start := time.Now()
+	m.declare("jenkins_up", "gauge", "Whether jenkins could be scraped")
+	m.declare("jenkins_node_executors", "gauge", "Executors per node by state (busy, idle or offline)")
+	m.declare("jenkins_label_executors", "gauge", "Executors per label by state (busy, idle or offline)")
+	m.declare("jenkins_node_offline", "gauge", "Whether the node is offline")
+	m.declare("jenkins_queue_length", "gauge", "Items in the build queue")
+	m.declare("jenkins_queue_blocked", "gauge", "Queue items that are blocked")
+	m.declare("jenkins_queue_stuck", "gauge", "Queue items that are stuck")
+	up := 1.0
+	if err := e.collectJenkins(m); err != nil {
+		fmt.Println("Could not scrape jenkins: " + err.Error())
+		up = 0
+	}
+	m.add("jenkins_up", "", up)
+	if e.nodelog != "" {
+		m.declare("jenkins_nodelog_up", "gauge", "Whether the nodelog store could be read")
+		m.declare("jenkins_nodelog_builds", "gauge", "Stored finished builds per job, host and result, drops when builds are pruned")
+		m.declare("jenkins_nodelog_build_duration_seconds", "histogram", "Duration of stored finished builds per job and host")
+		up := 1.0
+		if err := e.collectStore(m); err != nil {
+			fmt.Println("Could not read nodelog: " + err.Error())
+			up = 0
+		}
+		m.add("jenkins_nodelog_up", "", up)
+	}
+	m.declare("jenkins_scrape_duration_seconds", "gauge", "How long collecting these metrics took")
+	m.add("jenkins_scrape_duration_seconds", "", time.Since(start).Seconds())
+	return m
+}
+
+func (e *exporter) collectJenkins(m *metrics) error {
+	computers, err := e.j.Computers()
+	if err != nil {
+		return err
+	}
+	queue, err := e.j.Queue()
+	if err != nil {
+		return err
+	}
+	labelStates := make(map[string]int)
+	for _, c := range computers {
+		states := map[string]int{"busy": 0, "idle": 0, "offline": 0}
+		for _, executor := range c.Executors {
+			state := "idle"
+			if c.Offline {
+				state = "offline"
+			} else if !executor.Idle() {
+				state = "busy"
+			}
+			states[state]++
+			for _, label := range c.Labels {
+				labelStates[label+"\x00"+state]++
+			}
+		}
+		for _, state := range sortedKeys(states) {
+			m.add("jenkins_node_executors", "", float64(states[state]), "node", c.Name, "state", state)
+		}
+		offline := 0.0
+		if c.Offline {
+			offline = 1
+		}
+		m.add("jenkins_node_offline", "", offline, "node", c.Name)
+	}
+	for _, key := range sortedKeys(labelStates) {
+		parts := strings.SplitN(key, "\x00", 2)
+		m.add("jenkins_label_executors", "", float64(labelStates[key]), "label", parts[0], "state", parts[1])
+	}
+	blocked, stuck := 0, 0
+	for _, item := range queue {
+		if item.Blocked {
+			blocked++
+		}
+		if item.Stuck {
+			stuck++
+		}
+	}
+	m.add("jenkins_queue_length", "", float64(len(queue)))
+	m.add("jenkins_queue_blocked", "", float64(blocked))
+	m.add("jenkins_queue_stuck", "", float64(stuck))
+	return nil
+}
+
+func (e *exporter) storedStats() ([]storedStats, error) {
+	if e.stats != nil && time.Since(e.statsAt) < e.storeCache {
+		return e.stats, nil
+	}
+	var bounds []string
+	for _, bound := range durationBuckets {
+		bounds = append(bounds, formatValue(bound))
+	}
+	resp, err := e.client.Get(strings.TrimSuffix(e.nodelog, "/") + "/api/stats?buckets=" + strings.Join(bounds, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New("Got " + resp.Status + " from " + e.nodelog)
+	}
+	var stats []storedStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	for _, s := range stats {
+		if len(s.Buckets) != len(durationBuckets) {
+			return nil, errors.New("Expected " + strconv.Itoa(len(durationBuckets)) + " buckets from " + e.nodelog)
+		}
+	}
+	if stats == nil {
+		stats = []storedStats{}
+	}
+	e.stats = stats
+	e.statsAt = time.Now()
+	return stats, nil
+}
+
+func (e *exporter) collectStore(m *metrics) error {
+	stats, err := e.storedStats()
+	if err != nil {
+		return err
+	}
+	counts := make(map[string]int)
+	durations := make(map[string]*storedStats)
+	for _, s := range stats {
+		host := s.Host
+		if host == "" || strings.HasPrefix(host, "failure: ") {
+			host = "(unknown)"
+		}
+		counts[s.Job+"\x00"+host+"\x00"+s.Result] += s.Builds
+		key := s.Job + "\x00" + host
+		d, ok := durations[key]
+		if !ok {
+			d = &storedStats{Buckets: make([]int, len(durationBuckets))}
+			durations[key] = d
+		}
+		d.Timed += s.Timed
+		d.Seconds += s.Seconds
+		for i, n := range s.Buckets {
+			d.Buckets[i] += n
+		}
+	}
+	for _, key := range sortedKeys(counts) {
+		parts := strings.SplitN(key, "\x00", 3)
+		m.add("jenkins_nodelog_builds", "", float64(counts[key]), "job", parts[0], "host", parts[1], "result", parts[2])
+	}
+	keys := make(map[string]int)
+	for key, d := range durations {
+		if d.Timed > 0 {
+			keys[key] = 0
+		}
+	}
+	for _, key := range sortedKeys(keys) {
+		parts := strings.SplitN(key, "\x00", 2)
+		d := durations[key]
+		m.histogram("jenkins_nodelog_build_duration_seconds", durationBuckets, d.Buckets, d.Timed, d.Seconds, "job", parts[0], "host", parts[1])
+	}
+	return nil
+}
+
+func main() {
+	listen := flag.String("listen", ":9118", "Address to serve /metrics on")
+	profile := flag.String("profile", "", "Jenkins profile from ~/.jenkins (default $JENKINS_PROFILE or the first)")
+	url := flag.String("url", "", "Jenkins url, overrides the profile (use it to point at a fake jenkins)")
+	nodelog := flag.String("nodelog", "", "Url of a jenkins-nodelog -serve for build result and duration metrics")
+	cache := flag.Duration("cache", 15*time.Second, "Serve the same metrics for this long before asking jenkins again")
+	storeCache := flag.Duration("store-cache", 5*time.Minute, "Keep the nodelog build stats for this long")
+	timeout := flag.Duration("timeout", 10*time.Second, "Timeout for each request (overrides the profile)")
+	flag.Parse()
+	var j jenkins.Jenkins
+	if *url != "" {
+		j = jenkins.New(*url)
+	} else {
+		var err error
+		if j, err = jenkins.NewFromProfile(*profile); err != nil {
+			fmt.Println("Could not configure jenkins: " + err.Error())
+			return
+		}
+	}
+	jenkins.SetTimeout(*timeout)
+	e := &exporter{j: j, nodelog: *nodelog, cache: *cache, storeCache: *storeCache, client: &http.Client{Timeout: *timeout}}
+	http.Handle("/metrics", e)
+	fmt.Println("Serving metrics on " + *listen + "/metrics")
+	if err := http.ListenAndServe(*listen, nil); err != nil {
+		fmt.Println("Could not serve: " + err.Error())
+	}
+}
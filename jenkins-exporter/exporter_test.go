@@ -0,0 +1,106 @@
+package main
+
+import (
+	"github.com/jwiklund/jenkins"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeJenkins serves just enough of the jenkins api for the exporter and
+// counts the requests it gets
+func fakeJenkins(requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		switch r.URL.Path {
+		case "/computer/api/json":
+			w.Write([]byte(`{"computer":[
+ {"displayName":"node1","offline":false,"assignedLabels":[{"name":"linux"},{"name":"node1"}],
+  "executors":[{"number":0,"currentExecutable":{"fullDisplayName":"job1 #4","url":"http://x/job/job1/4/","timestamp":1}},{"number":1}]},
+ {"displayName":"node2","offline":true,"offlineCauseReason":"disk","assignedLabels":[{"name":"linux"}],
+  "executors":[{"number":0}]}]}`))
+		case "/queue/api/json":
+			w.Write([]byte(`{"items":[{"id":1,"blocked":true,"task":{"name":"job1"}},{"id":2,"stuck":true,"task":{"name":"job2"}}]}`))
+		case "/api/stats":
+			if r.URL.Query().Get("buckets") != "60,300,600,1200,1800,3600,7200,14400" {
+				http.Error(w, "unexpected buckets", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`[{"job":"job1","host":"failure: no console","result":"FAILURE","builds":1,"timed":1,"seconds":1,"buckets":[1,1,1,1,1,1,1,1]},
+ {"job":"job1","host":"node1","result":"FAILURE","builds":1,"timed":1,"seconds":400,"buckets":[0,0,1,1,1,1,1,1]},
+ {"job":"job1","host":"node1","result":"SUCCESS","builds":3,"timed":1,"seconds":90,"buckets":[0,1,1,1,1,1,1,1]}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func scrape(t *testing.T, server *httptest.Server) string {
+	resp, err := http.Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	requests := 0
+	fake := fakeJenkins(&requests)
+	defer fake.Close()
+	e := &exporter{j: jenkins.New(fake.URL), nodelog: fake.URL, cache: time.Minute, storeCache: time.Minute, client: http.DefaultClient}
+	server := httptest.NewServer(e)
+	defer server.Close()
+	body := scrape(t, server)
+	for _, expected := range []string{
+		"# TYPE jenkins_node_executors gauge",
+		`jenkins_node_executors{node="node1",state="busy"} 1`,
+		`jenkins_node_executors{node="node1",state="idle"} 1`,
+		`jenkins_node_executors{node="node2",state="offline"} 1`,
+		`jenkins_label_executors{label="linux",state="busy"} 1`,
+		`jenkins_label_executors{label="linux",state="offline"} 1`,
+		`jenkins_node_offline{node="node2"} 1`,
+		"jenkins_queue_length 2",
+		"jenkins_queue_blocked 1",
+		"jenkins_queue_stuck 1",
+		"jenkins_up 1",
+		"# TYPE jenkins_nodelog_builds gauge",
+		`jenkins_nodelog_builds{job="job1",host="node1",result="FAILURE"} 1`,
+		`jenkins_nodelog_builds{job="job1",host="node1",result="SUCCESS"} 3`,
+		`jenkins_nodelog_builds{job="job1",host="(unknown)",result="FAILURE"} 1`,
+		`jenkins_nodelog_build_duration_seconds_bucket{job="job1",host="node1",le="300"} 1`,
+		`jenkins_nodelog_build_duration_seconds_bucket{job="job1",host="node1",le="+Inf"} 2`,
+		`jenkins_nodelog_build_duration_seconds_sum{job="job1",host="node1"} 490`,
+		"jenkins_nodelog_up 1",
+	} {
+		if !strings.Contains(body, expected+"\n") {
+			t.Fatalf("Expected %q in\n%s", expected, body)
+		}
+	}
+	before := requests
+	scrape(t, server)
+	if requests != before {
+		t.Fatalf("Expected a cached scrape but jenkins got %d more requests", requests-before)
+	}
+}
+
+func TestMetricsDown(t *testing.T) {
+	fake := httptest.NewServer(http.NotFoundHandler())
+	defer fake.Close()
+	e := &exporter{j: jenkins.New(fake.URL), cache: time.Minute, client: http.DefaultClient}
+	server := httptest.NewServer(e)
+	defer server.Close()
+	if body := scrape(t, server); !strings.Contains(body, "jenkins_up 0\n") {
+		t.Fatalf("Expected jenkins to be down in\n%s", body)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	if l := labels("reason", "a \"b\"\\\n"); l != `{reason="a \"b\"\\\n"}` {
+		t.Fatalf("Unexpected labels %s", l)
+	}
+}
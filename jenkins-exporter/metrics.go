@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// metrics collects samples and writes them in the Prometheus text format,
+// grouped by metric name in the order they were first declared
+type metrics struct {
+	names   []string
+	help    map[string]string
+	kind    map[string]string
+	samples map[string][]string
+}
+
+func newMetrics() *metrics {
+	return &metrics{help: make(map[string]string), kind: make(map[string]string), samples: make(map[string][]string)}
+}
+
+func (m *metrics) declare(name, kind, help string) {
+	if _, ok := m.help[name]; !ok {
+		m.names = append(m.names, name)
+	}
+	m.help[name] = help
+	m.kind[name] = kind
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labels formats pairs of label names and values like {node="a",state="idle"}
+func labels(pairs ...string) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var parts []string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// add records a sample of the declared family name, suffix is used for
+// the _bucket, _sum and _count series of histograms
+func (m *metrics) add(name, suffix string, v float64, pairs ...string) {
+	m.samples[name] = append(m.samples[name], name+suffix+labels(pairs...)+" "+formatValue(v))
+}
+
+// histogram adds the buckets, sum and count of total values that are
+// already counted, counts[i] is how many are at most bounds[i]
+func (m *metrics) histogram(name string, bounds []float64, counts []int, total int, sum float64, pairs ...string) {
+	for i, bound := range bounds {
+		m.add(name, "_bucket", float64(counts[i]), append(append([]string(nil), pairs...), "le", formatValue(bound))...)
+	}
+	m.add(name, "_bucket", float64(total), append(append([]string(nil), pairs...), "le", "+Inf")...)
+	m.add(name, "_sum", sum, pairs...)
+	m.add(name, "_count", float64(total), pairs...)
+}
+
+func (m *metrics) write(w io.Writer) error {
+	for _, name := range m.names {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, m.help[name], name, m.kind[name]); err != nil {
+			return err
+		}
+		for _, sample := range m.samples[name] {
+			if _, err := fmt.Fprintln(w, sample); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]int) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jwiklund/jenkins"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// describe is one line about a build: job, number, result, node, duration
+func describe(info jenkins.BuildInfo) string {
+	result := info.Result
+	if info.Building || result == "" {
+		result = "BUILDING"
+	}
+	node := info.BuiltOn
+	if node == "" {
+		node = "built-in"
+	}
+	text := info.Job + " #" + strconv.Itoa(info.Number) + " " + result + " on " + node
+	if !info.Building {
+		text += " in " + (time.Duration(info.Duration) * time.Millisecond).Truncate(time.Second).String()
+	}
+	return text
+}
+
+// writeAscii draws the tree with the selected build marked <==
+func writeAscii(w io.Writer, node jenkins.BuildNode, prefix, branch, indent string) {
+	line := prefix + branch + describe(node.Info)
+	if node.Selected {
+		line += " <=="
+	}
+	fmt.Fprintln(w, line)
+	for i, downstream := range node.Downstream {
+		if i == len(node.Downstream)-1 {
+			writeAscii(w, downstream, prefix+indent, "`-- ", "    ")
+		} else {
+			writeAscii(w, downstream, prefix+indent, "|-- ", "|   ")
+		}
+	}
+}
+
+func dotColor(info jenkins.BuildInfo) string {
+	switch {
+	case info.Building || info.Result == "":
+		return "lightblue"
+	case info.Result == "SUCCESS":
+		return "palegreen"
+	case info.Result == "FAILURE":
+		return "salmon"
+	case info.Result == "UNSTABLE":
+		return "gold"
+	}
+	return "lightgrey"
+}
+
+func dotId(info jenkins.BuildInfo) string {
+	return strconv.Quote(info.Job + "#" + strconv.Itoa(info.Number))
+}
+
+func writeDotNodes(w io.Writer, node jenkins.BuildNode) {
+	attrs := "label=" + strconv.Quote(strings.Replace(describe(node.Info), " on ", "\non ", 1)) + ", fillcolor=" + dotColor(node.Info)
+	if node.Selected {
+		attrs += ", penwidth=3"
+	}
+	fmt.Fprintf(w, "  %s [%s];\n", dotId(node.Info), attrs)
+	for _, downstream := range node.Downstream {
+		fmt.Fprintf(w, "  %s -> %s;\n", dotId(node.Info), dotId(downstream.Info))
+		writeDotNodes(w, downstream)
+	}
+}
+
+func writeDot(w io.Writer, node jenkins.BuildNode) {
+	fmt.Fprintln(w, "digraph builds {")
+	fmt.Fprintln(w, "  node [shape=box, style=filled];")
+	writeDotNodes(w, node)
+	fmt.Fprintln(w, "}")
+}
+
+func main() {
+	format := flag.String("format", "ascii", "Output format: ascii or dot (Graphviz)")
+	profile := flag.String("profile", "", "Jenkins profile from ~/.jenkins (default $JENKINS_PROFILE or the first)")
+	flag.Parse()
+	if len(flag.Args()) != 1 {
+		fmt.Println("Specify one build, like job#123 or a build url")
+		os.Exit(1)
+	}
+	if *format != "ascii" && *format != "dot" {
+		fmt.Println("Unknown format " + *format + ", use ascii or dot")
+		os.Exit(1)
+	}
+	ref, err := jenkins.ParseBuildRef(flag.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	j, err := jenkins.NewFromProfile(*profile)
+	if err != nil {
+		fmt.Println("Could not configure jenkins: " + err.Error())
+		os.Exit(1)
+	}
+	graph, err := j.BuildGraph(ref)
+	if err != nil {
+		fmt.Println("Could not follow " + ref.String() + ": " + err.Error())
+		os.Exit(1)
+	}
+	if *format == "dot" {
+		writeDot(os.Stdout, graph)
+	} else {
+		writeAscii(os.Stdout, graph, "", "", "")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"github.com/jwiklund/jenkins"
+	"strings"
+	"testing"
+)
+
+func testGraph() jenkins.BuildNode {
+	return jenkins.BuildNode{
+		Info: jenkins.BuildInfo{Job: "compile", Number: 455, Result: "SUCCESS", BuiltOn: "agent1", Duration: 61000},
+		Downstream: []jenkins.BuildNode{
+			{Info: jenkins.BuildInfo{Job: "integration", Number: 812, Result: "FAILURE", BuiltOn: "agent7", Duration: 2000}, Selected: true,
+				Downstream: []jenkins.BuildNode{{Info: jenkins.BuildInfo{Job: "folder/deploy", Number: 3, Building: true}}}},
+			{Info: jenkins.BuildInfo{Job: "docs", Number: 100, Result: "ABORTED"}},
+		},
+	}
+}
+
+func TestWriteAscii(t *testing.T) {
+	var out bytes.Buffer
+	writeAscii(&out, testGraph(), "", "", "")
+	expected := strings.Join([]string{
+		"compile #455 SUCCESS on agent1 in 1m1s",
+		"|-- integration #812 FAILURE on agent7 in 2s <==",
+		"|   `-- folder/deploy #3 BUILDING on built-in",
+		"`-- docs #100 ABORTED on built-in in 0s",
+	}, "\n") + "\n"
+	if out.String() != expected {
+		t.Fatalf("Expected\n%s\nbut got\n%s", expected, out.String())
+	}
+}
+
+func TestWriteDot(t *testing.T) {
+	var out bytes.Buffer
+	writeDot(&out, testGraph())
+	for _, line := range []string{
+		`  "compile#455" -> "integration#812";`,
+		`  "integration#812" [label="integration #812 FAILURE\non agent7 in 2s", fillcolor=salmon, penwidth=3];`,
+		`  "integration#812" -> "folder/deploy#3";`,
+		`  "folder/deploy#3" [label="folder/deploy #3 BUILDING\non built-in", fillcolor=lightblue];`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Fatalf("Expected %s in\n%s", line, out.String())
+		}
+	}
+}
@@ -4,19 +4,8 @@ import (
 	"flag"
 	"fmt"
 	"github.com/jwiklund/jenkins"
-	"strings"
 )
 
-func nameMatch(name string, match []string) bool {
-	for _, part := range match {
-		ind := strings.Index(strings.ToLower(name), strings.ToLower(part))
-		if ind == -1 {
-			return false
-		}
-	}
-	return true
-}
-
 func main() {
 	j, err := jenkins.NewFromConfig()
 	if err != nil {
@@ -30,7 +19,7 @@ func main() {
 		return
 	}
 	for _, build := range builds {
-		if nameMatch(build.Node, flag.Args()) || nameMatch(build.Build, flag.Args()) {
+		if jenkins.NameMatch(build.Node, flag.Args()) || jenkins.NameMatch(build.Build, flag.Args()) {
 			info, err := j.NodeInfo(build.Node)
 			if err != nil {
 				fmt.Println("Could not get info about " + build.Node + ": " + err.Error())
@@ -1,16 +1,42 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/jwiklund/jenkins"
+	"os"
+	"time"
 )
 
+func watch(j jenkins.Jenkins, interval time.Duration, asJson bool) {
+	encoder := json.NewEncoder(os.Stdout)
+	for event := range j.Watch(context.Background(), interval) {
+		if asJson {
+			if err := encoder.Encode(event); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+			}
+		} else {
+			fmt.Println(event.String())
+		}
+	}
+}
+
 func main() {
+	w := flag.Bool("watch", false, "Keep polling and print changes only")
+	interval := flag.Duration("interval", 10*time.Second, "Poll interval for -watch")
+	asJson := flag.Bool("json", false, "Print -watch events as JSON lines")
+	flag.Parse()
 	j, err := jenkins.NewFromConfig()
 	if err != nil {
 		fmt.Println("Could not configure jenkins: " + err.Error())
 		return
 	}
+	if *w {
+		watch(j, *interval, *asJson)
+		return
+	}
 	builds, err := j.Builds()
 	if err != nil {
 		fmt.Println(err.Error())
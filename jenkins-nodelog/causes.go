@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"github.com/jwiklund/jenkins"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CauseRule recognizes a failure cause from a console line. Category is
+// infra for problems with the build host and anything else (test, build)
+// for problems with what was built.
+type CauseRule struct {
+	Category string
+	Name     string
+	Pattern  *regexp.Regexp
+}
+
+const infraCategory = "infra"
+
+// defaultCauses are tried in order and the first rule that matches any
+// line wins, so infrastructure problems come before their symptoms
+var defaultCauses = []struct{ category, name, pattern string }{
+	{"infra", "oom", `OutOfMemoryError|Cannot allocate memory|Out of memory: Kill`},
+	{"infra", "disk-full", `No space left on device|Disk quota exceeded`},
+	{"infra", "host-key", `Host key verification failed\.`},
+	{"infra", "network", `(Connection|Read|connect) timed out|Connection refused|UnknownHostException|Could not resolve host|Network is unreachable`},
+	{"build", "compilation", `COMPILATION ERROR|Compilation failure|error: cannot find symbol`},
+	{"test", "test-failure", `There are test failures|Tests run: .*Failures: [1-9]|FAILED TESTS`},
+}
+
+func DefaultCauseRules() []CauseRule {
+	var rules []CauseRule
+	for _, c := range defaultCauses {
+		rules = append(rules, CauseRule{c.category, c.name, regexp.MustCompile(c.pattern)})
+	}
+	return rules
+}
+
+// ParseCauseRules reads one rule per line as "category name regexp", empty
+// lines and lines starting with # are skipped
+func ParseCauseRules(text string) ([]CauseRule, error) {
+	var rules []CauseRule
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) != 3 {
+			return nil, errors.New("Line " + strconv.Itoa(i+1) + ": expected category, name and pattern")
+		}
+		re, err := regexp.Compile(strings.TrimSpace(fields[2]))
+		if err != nil {
+			return nil, errors.New("Line " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+		rules = append(rules, CauseRule{fields[0], fields[1], re})
+	}
+	return rules, nil
+}
+
+// LoadCauseRules reads rules from path, the defaults when path is empty
+func LoadCauseRules(path string) ([]CauseRule, error) {
+	if path == "" {
+		return DefaultCauseRules(), nil
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCauseRules(string(text))
+}
+
+// FailureCause is the first rule matching the console of a build that did
+// not succeed, with the line it matched
+type FailureCause struct {
+	Server   string `json:"server"`
+	Job      string `json:"job"`
+	Number   int    `json:"number"`
+	Category string `json:"category"`
+	Cause    string `json:"cause"`
+	Excerpt  string `json:"excerpt"`
+}
+
+func causeKey(server, job string, number int) string {
+	return server + " " + job + " " + strconv.Itoa(number)
+}
+
+// causeMap indexes causes by causeKey
+func causeMap(causes []FailureCause) map[string]FailureCause {
+	m := make(map[string]FailureCause)
+	for _, c := range causes {
+		m[causeKey(c.Server, c.Job, c.Number)] = c
+	}
+	return m
+}
+
+const maxExcerpt = 200
+
+// ScanConsole reads the console once for the host and, when rules are
+// given, the failure cause. Without rules it stops at the host line.
+func ScanConsole(j jenkins.Jenkins, ref jenkins.BuildRef, rules []CauseRule) (string, *FailureCause, error) {
+	console, err := j.Console(ref)
+	if err != nil {
+		return "", nil, err
+	}
+	defer console.Close()
+	host := ""
+	hostErr := errors.New("No Host")
+	best := len(rules)
+	var cause *FailureCause
+	r := bufio.NewReader(console)
+	line, err := r.ReadString('\n')
+	for err == nil || line != "" {
+		if hostErr != nil && strings.Index(line, "Node Controller:") == 0 {
+			ctrl := strings.Trim(strings.Split(line, ":")[1], " \r\n\t")
+			if ctrl == "" {
+				hostErr = errors.New("Empty Host")
+			} else if ctrl == "Host key verification failed." {
+				hostErr = errors.New(ctrl)
+			} else {
+				host, hostErr = ctrl, nil
+			}
+			if len(rules) == 0 {
+				break
+			}
+		}
+		for i := 0; i < best; i++ {
+			if rules[i].Pattern.MatchString(line) {
+				excerpt := strings.TrimSpace(line)
+				if len(excerpt) > maxExcerpt {
+					excerpt = excerpt[0:maxExcerpt]
+				}
+				cause = &FailureCause{Job: ref.Job, Number: ref.Number, Category: rules[i].Category, Cause: rules[i].Name, Excerpt: excerpt}
+				best = i
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+		line, err = r.ReadString('\n')
+	}
+	return host, cause, hostErr
+}
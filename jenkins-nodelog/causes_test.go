@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"github.com/jwiklund/jenkins"
+	"testing"
+)
+
+func TestScanConsole(t *testing.T) {
+	j := newFake()
+	j.consoles["job1#2"] = "Started\nNode Controller: host2\n[ERROR] Tests run: 10, Failures: 1, Errors: 0\n" +
+		"java.lang.OutOfMemoryError: Java heap space\nFinished: FAILURE"
+	host, cause, err := ScanConsole(j, jenkins.BuildRef{Job: "job1", Number: 2}, DefaultCauseRules())
+	if err != nil || host != "host2" {
+		t.Fatalf("Expected host2 but got %s, %v", host, err)
+	}
+	if cause == nil || cause.Cause != "oom" || cause.Category != "infra" || cause.Excerpt != "java.lang.OutOfMemoryError: Java heap space" {
+		t.Fatalf("Expected the out of memory error to win over the test failure but got %+v", cause)
+	}
+	host, cause, err = ScanConsole(j, jenkins.BuildRef{Job: "job1", Number: 1}, DefaultCauseRules())
+	if err != nil || host != "host1" || cause != nil {
+		t.Fatalf("Expected host1 and no cause but got %s, %+v, %v", host, cause, err)
+	}
+	j.consoles["job1#1"] = "Node Controller: Host key verification failed.\n"
+	_, cause, err = ScanConsole(j, jenkins.BuildRef{Job: "job1", Number: 1}, DefaultCauseRules())
+	if err == nil || err.Error() != "Host key verification failed." || cause == nil || cause.Cause != "host-key" {
+		t.Fatalf("Expected host key failure but got %+v, %v", cause, err)
+	}
+}
+
+func TestParseCauseRules(t *testing.T) {
+	rules, err := ParseCauseRules("# local rules\ninfra docker Cannot connect to the Docker daemon\n\ntest flaky FLAKY (test|spec)\n")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(rules) != 2 || rules[0].Name != "docker" || !rules[0].Pattern.MatchString("Cannot connect to the Docker daemon at unix://") ||
+		rules[1].Category != "test" || !rules[1].Pattern.MatchString("FLAKY spec") {
+		t.Fatalf("Unexpected rules %+v", rules)
+	}
+	if _, err := ParseCauseRules("infra broken"); err == nil {
+		t.Fatal("Expected missing pattern to fail")
+	}
+	if _, err := ParseCauseRules("infra broken ("); err == nil {
+		t.Fatal("Expected invalid pattern to fail")
+	}
+}
+
+func TestRefreshCauses(t *testing.T) {
+	j := newFake()
+	j.consoles["job1#1"] += "No space left on device\n"
+	j.consoles["job1#2"] = "Started\nNode Controller: host2\nThere are test failures.\nFinished: FAILURE\n"
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1"})
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{Causes: DefaultCauseRules()})
+	causes, _ := store.GetCauses()
+	if len(causes) != 1 || causes[0] != (FailureCause{"http://fake/jenkins", "job1", 2, "test", "test-failure", "There are test failures."}) {
+		t.Fatalf("Expected only the failed build to be classified but got %+v", causes)
+	}
+	builds, _ := store.GetBuilds("http://fake/jenkins", "job1")
+	stats := HostReport(builds, causeMap(causes), 2)
+	for _, h := range stats {
+		if h.Host == "host2" && (h.Tests != 1 || h.Infra != 0) {
+			t.Fatalf("Expected a test failure on host2 but got %+v", h)
+		}
+	}
+	store.DeleteBuild(builds[1])
+	if causes, _ = store.GetCauses(); len(causes) != 0 {
+		t.Fatalf("Expected the cause to go with the build but got %+v", causes)
+	}
+}
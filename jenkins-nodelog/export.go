@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var exportFormats = []string{"csv", "jsonl", "sqlite"}
+
+// ExportOptions selects and formats the exported builds. Job and Host are
+// regular expressions, Since and Until are windows or dates as taken by
+// parseTime and Numbers is a build number range like 100-200, 100- or 150.
+// Out is the file to write instead of the given writer, it is required for
+// sqlite and only created once everything else is known to be valid.
+type ExportOptions struct {
+	Format  string
+	Out     string
+	Job     string
+	Host    string
+	Result  string
+	Since   string
+	Until   string
+	Numbers string
+	ISO     bool
+}
+
+func parseNumbers(numbers string) (int, int, error) {
+	if numbers == "" {
+		return 0, 0, nil
+	}
+	invalid := errors.New("Invalid build numbers " + numbers + ", use a range like 100-200, 100- or -200")
+	parts := strings.SplitN(numbers, "-", 2)
+	var err error
+	first, last := 0, 0
+	if parts[0] != "" {
+		if first, err = strconv.Atoi(parts[0]); err != nil {
+			return 0, 0, invalid
+		}
+	}
+	if len(parts) == 1 {
+		return first, first, nil
+	}
+	if parts[1] != "" {
+		if last, err = strconv.Atoi(parts[1]); err != nil {
+			return 0, 0, invalid
+		}
+	}
+	return first, last, nil
+}
+
+// exportedBuilds loads the builds selected by opts, ordered by server, job
+// and number
+func exportedBuilds(store BuildStore, opts ExportOptions) ([]Build, error) {
+	var query BuildQuery
+	var err error
+	query.Result = opts.Result
+	now := time.Now()
+	if opts.Since != "" {
+		if query.From, err = parseTime(opts.Since, now); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Until != "" {
+		if query.To, err = parseTime(opts.Until, now); err != nil {
+			return nil, err
+		}
+	}
+	first, last, err := parseNumbers(opts.Numbers)
+	if err != nil {
+		return nil, err
+	}
+	var job, host *regexp.Regexp
+	if opts.Job != "" {
+		if job, err = regexp.Compile(opts.Job); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Host != "" {
+		if host, err = regexp.Compile(opts.Host); err != nil {
+			return nil, err
+		}
+	}
+	all, err := store.QueryBuilds(query)
+	if err != nil {
+		return nil, err
+	}
+	var builds []Build
+	for _, build := range all {
+		if (job != nil && !job.MatchString(build.Job)) ||
+			(host != nil && !host.MatchString(build.Host)) ||
+			(first != 0 && build.Number < first) ||
+			(last != 0 && build.Number > last) {
+			continue
+		}
+		builds = append(builds, build)
+	}
+	return builds, nil
+}
+
+func isoTime(ms int64) string {
+	if ms < 0 {
+		return ""
+	}
+	return time.Unix(ms/1000, (ms%1000)*int64(time.Millisecond)).Format(time.RFC3339)
+}
+
+func humanDuration(ms int64) string {
+	if ms < 0 {
+		return ""
+	}
+	return msDuration(ms)
+}
+
+type exportedBuild struct {
+	Server   string      `json:"server"`
+	Job      string      `json:"job"`
+	Number   int         `json:"number"`
+	Start    interface{} `json:"start"`
+	Duration interface{} `json:"duration"`
+	Host     string      `json:"host"`
+	Result   string      `json:"result"`
+	Failed   int         `json:"failed"`
+	Total    int         `json:"total"`
+}
+
+func writeCSV(w io.Writer, builds []Build, iso bool) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"Job", "Number", "Host", "Duration", "Start", "Result", "Failed", "Total", "Server"})
+	for _, build := range builds {
+		start := strconv.FormatInt(build.Start, 10)
+		duration := strconv.FormatInt(build.Duration, 10)
+		if iso {
+			start = isoTime(build.Start)
+			duration = humanDuration(build.Duration)
+		}
+		cw.Write([]string{build.Job, strconv.Itoa(build.Number), build.Host, duration, start, build.Result,
+			strconv.Itoa(build.Failed), strconv.Itoa(build.Total), build.Server})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJSONLines(w io.Writer, builds []Build, iso bool) error {
+	encoder := json.NewEncoder(w)
+	for _, build := range builds {
+		exported := exportedBuild{build.Server, build.Job, build.Number, build.Start, build.Duration,
+			build.Host, build.Result, build.Failed, build.Total}
+		if iso {
+			exported.Start = isoTime(build.Start)
+			exported.Duration = humanDuration(build.Duration)
+		}
+		if err := encoder.Encode(exported); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSQLite copies the builds with their jobs, failure causes, metadata
+// and stages to the SQLite store at path, which is created or migrated as
+// needed
+func writeSQLite(store BuildStore, path string, builds []Build) error {
+	causes, err := store.GetCauses()
+	if err != nil {
+		return err
+	}
+	byBuild := causeMap(causes)
+	metas := make(map[string]BuildMeta)
+	selected := NewMemoryStore()
+	for _, build := range builds {
+		if _, err := selected.GetJob(build.Server, build.Job); err != nil {
+			job, err := store.GetJob(build.Server, build.Job)
+			if err != nil {
+				job = Job{Server: build.Server, Name: build.Job}
+			}
+			selected.PutJob(job)
+			jobMetas, err := store.GetMetas(build.Server, build.Job)
+			if err != nil {
+				return err
+			}
+			for _, meta := range jobMetas {
+				metas[causeKey(meta.Server, meta.Job, meta.Number)] = meta
+			}
+		}
+		selected.InsertBuild(build)
+		key := causeKey(build.Server, build.Job, build.Number)
+		if cause, ok := byBuild[key]; ok {
+			selected.PutCause(cause)
+		}
+		if meta, ok := metas[key]; ok {
+			selected.PutMeta(meta)
+		}
+	}
+	out, err := OpenSQLite(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if err := out.Lock(); err != nil {
+		return err
+	}
+	if err := out.Begin(); err != nil {
+		return err
+	}
+	if _, err := CopyStore(selected, out); err != nil {
+		return err
+	}
+	return out.Commit()
+}
+
+func ExportBuilds(w io.Writer, store BuildStore, opts ExportOptions) error {
+	switch opts.Format {
+	case "csv", "jsonl":
+	case "sqlite":
+		if opts.Out == "" {
+			return errors.New("Export to sqlite needs -out")
+		}
+	default:
+		return errors.New("Unknown export format " + opts.Format + ", use one of " + strings.Join(exportFormats, ", "))
+	}
+	builds, err := exportedBuilds(store, opts)
+	if err != nil {
+		return err
+	}
+	if opts.Format == "sqlite" {
+		return writeSQLite(store, opts.Out, builds)
+	}
+	write := writeJSONLines
+	if opts.Format == "csv" {
+		write = writeCSV
+	}
+	if opts.Out == "" {
+		return write(w, builds, opts.ISO)
+	}
+	f, err := os.Create(opts.Out)
+	if err != nil {
+		return errors.New("Could not create " + opts.Out + ": " + err.Error())
+	}
+	err = write(f, builds, opts.ISO)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportStore() *MemoryStore {
+	store := NewMemoryStore()
+	store.PutJob(Job{"http://fake/jenkins", "job1", "http://fake/jenkins/job/job1/", 0})
+	store.PutJob(Job{"http://fake/jenkins", "job,2", "http://fake/jenkins/job/job,2/", 0})
+	store.InsertBuild(Build{"http://fake/jenkins", "job1", 1, 1000, 90500, "host1", "SUCCESS", -1, -1})
+	store.InsertBuild(Build{"http://fake/jenkins", "job1", 2, 2000, 10, "host2", "FAILURE", 1, 10})
+	store.InsertBuild(Build{"http://fake/jenkins", "job,2", 1, 3000, 10, "host \"3\", rack 1", "SUCCESS", 0, 5})
+	store.PutCause(FailureCause{"http://fake/jenkins", "job1", 2, "test", "test-failure", "There are test failures."})
+	store.PutMeta(BuildMeta{Server: "http://fake/jenkins", Job: "job1", Number: 1, BuiltOn: "host1"})
+	store.PutMeta(BuildMeta{Server: "http://fake/jenkins", Job: "job1", Number: 2, BuiltOn: "host2",
+		Stages: []Stage{{"Test", "FAILED", "agent3", 2000, 10}}})
+	return store
+}
+
+func TestExportCSV(t *testing.T) {
+	var out bytes.Buffer
+	if err := ExportBuilds(&out, exportStore(), ExportOptions{Format: "csv"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	records, err := csv.NewReader(&out).ReadAll()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(records) != 4 || records[1][0] != "job,2" || records[1][2] != "host \"3\", rack 1" {
+		t.Fatalf("Unexpected records %v", records)
+	}
+}
+
+func TestExportJSONLines(t *testing.T) {
+	var out bytes.Buffer
+	opts := ExportOptions{Format: "jsonl", Job: "^job1$", ISO: true}
+	if err := ExportBuilds(&out, exportStore(), opts); err != nil {
+		t.Fatal(err.Error())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines but got %q", out.String())
+	}
+	var build map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &build); err != nil {
+		t.Fatal(err.Error())
+	}
+	if build["duration"] != "1m30s" || build["start"] != time.Unix(1, 0).Format(time.RFC3339) || build["failed"] != -1.0 {
+		t.Fatalf("Unexpected build %v", build)
+	}
+}
+
+func TestExportFilters(t *testing.T) {
+	for _, c := range []struct {
+		opts     ExportOptions
+		expected int
+	}{
+		{ExportOptions{Result: "SUCCESS"}, 2},
+		{ExportOptions{Host: "^host[12]$"}, 2},
+		{ExportOptions{Numbers: "2-"}, 1},
+		{ExportOptions{Numbers: "1"}, 2},
+		{ExportOptions{Since: time.Unix(2, 0).Format(time.RFC3339)}, 2},
+		{ExportOptions{Until: time.Unix(2, 0).Format(time.RFC3339)}, 1},
+	} {
+		builds, err := exportedBuilds(exportStore(), c.opts)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if len(builds) != c.expected {
+			t.Fatalf("Expected %d builds for %+v but got %v", c.expected, c.opts, builds)
+		}
+	}
+	if _, err := exportedBuilds(exportStore(), ExportOptions{Numbers: "a-b"}); err == nil {
+		t.Fatalf("Expected invalid range to fail")
+	}
+}
+
+func TestExportSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.db")
+	if err := ExportBuilds(nil, exportStore(), ExportOptions{Format: "sqlite", Out: path, Result: "FAILURE"}); err != nil {
+		t.Fatal(err.Error())
+	}
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	info, _ := store.Info()
+	if info.Jobs != 1 || info.Builds != 1 {
+		t.Fatalf("Expected only the failed build and its job but got %+v", info)
+	}
+	causes, _ := store.GetCauses()
+	if len(causes) != 1 || causes[0].Number != 2 {
+		t.Fatalf("Expected the cause of the failed build only but got %+v", causes)
+	}
+	metas, _ := store.GetMetas("http://fake/jenkins", "job1")
+	if len(metas) != 1 || metas[0].Number != 2 || len(metas[0].Stages) != 1 || metas[0].Stages[0].Host != "agent3" {
+		t.Fatalf("Expected the metadata and stages of the failed build only but got %+v", metas)
+	}
+}
+
+func TestExportKeepsOutOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "builds.csv")
+	if err := os.WriteFile(path, []byte("keep"), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, opts := range []ExportOptions{{Format: "xml", Out: path}, {Format: "csv", Out: path, Numbers: "a-b"}} {
+		if err := ExportBuilds(nil, exportStore(), opts); err == nil {
+			t.Fatalf("Expected %+v to fail", opts)
+		}
+	}
+	if data, _ := os.ReadFile(path); string(data) != "keep" {
+		t.Fatalf("Expected %s to be left alone but got %q", path, data)
+	}
+	if err := ExportBuilds(nil, exportStore(), ExportOptions{Format: "jsonl", Out: path}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if data, _ := os.ReadFile(path); strings.Count(string(data), "\n") != 3 {
+		t.Fatalf("Expected 3 builds in %s but got %q", path, data)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const unknownHost = "(unknown)"
+
+type HostStats struct {
+	Host        string `json:"host"`
+	Builds      int    `json:"builds"`
+	Failures    int    `json:"failures"`
+	Unstable    int    `json:"unstable"`
+	Median      int64  `json:"median"`
+	P95         int64  `json:"p95"`
+	TestsFailed int    `json:"testsFailed"`
+	TestsTotal  int    `json:"testsTotal"`
+	// Infra and Tests count the unsuccessful builds classified as caused
+	// by the host and by failing tests
+	Infra int `json:"infra"`
+	Tests int `json:"tests"`
+	// Expected is how many failures the host would have had if each of
+	// its builds failed as often as that job does on every host
+	Expected float64 `json:"expected"`
+	Score    float64 `json:"score"`
+	Flagged  bool    `json:"flagged"`
+}
+
+func (h HostStats) FailureRate() float64 {
+	return ratio(h.Failures, h.Builds)
+}
+
+func (h HostStats) UnstableRate() float64 {
+	return ratio(h.Unstable, h.Builds)
+}
+
+func (h HostStats) TestFailureRate() float64 {
+	return ratio(h.TestsFailed, h.TestsTotal)
+}
+
+func ratio(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+// percentile uses the nearest rank on sorted values
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// hostName groups the "failure: ..." hosts from unreadable consoles together
+func hostName(b Build) string {
+	if b.Host == "" || strings.HasPrefix(b.Host, "failure: ") {
+		return unknownHost
+	}
+	return b.Host
+}
+
+// HostReport computes statistics per host for finished builds. A host is
+// flagged when its failures are more than threshold standard deviations
+// above what the job baselines predict. causes, by causeKey, splits the
+// failures into infrastructure and test failures.
+func HostReport(builds []Build, causes map[string]FailureCause, threshold float64) []HostStats {
+	jobBuilds := make(map[string]int)
+	jobFailures := make(map[string]int)
+	for _, b := range builds {
+		if b.Result == "" {
+			continue
+		}
+		jobBuilds[b.Server+" "+b.Job]++
+		if b.Result == "FAILURE" {
+			jobFailures[b.Server+" "+b.Job]++
+		}
+	}
+	hosts := make(map[string]*HostStats)
+	durations := make(map[string][]int64)
+	variance := make(map[string]float64)
+	for _, b := range builds {
+		if b.Result == "" {
+			continue
+		}
+		name := hostName(b)
+		h, ok := hosts[name]
+		if !ok {
+			h = &HostStats{Host: name}
+			hosts[name] = h
+		}
+		h.Builds++
+		switch b.Result {
+		case "FAILURE":
+			h.Failures++
+		case "UNSTABLE":
+			h.Unstable++
+		}
+		if c, ok := causes[causeKey(b.Server, b.Job, b.Number)]; ok && unsuccessful(b.Result) {
+			switch c.Category {
+			case infraCategory:
+				h.Infra++
+			case "test":
+				h.Tests++
+			}
+		}
+		if b.Total > 0 {
+			h.TestsFailed += b.Failed
+			h.TestsTotal += b.Total
+		}
+		durations[name] = append(durations[name], b.Duration)
+		p := ratio(jobFailures[b.Server+" "+b.Job], jobBuilds[b.Server+" "+b.Job])
+		h.Expected += p
+		variance[name] += p * (1 - p)
+	}
+	var stats []HostStats
+	for name, h := range hosts {
+		sort.Slice(durations[name], func(a, b int) bool { return durations[name][a] < durations[name][b] })
+		h.Median = percentile(durations[name], 0.5)
+		h.P95 = percentile(durations[name], 0.95)
+		excess := float64(h.Failures) - h.Expected
+		if variance[name] > 0 {
+			h.Score = excess / math.Sqrt(variance[name])
+		}
+		h.Flagged = excess > 0 && h.Score > threshold
+		stats = append(stats, *h)
+	}
+	sort.Slice(stats, func(a, b int) bool {
+		if stats[a].Score != stats[b].Score {
+			return stats[a].Score > stats[b].Score
+		}
+		return stats[a].Host < stats[b].Host
+	})
+	return stats
+}
+
+// parseSince accepts go durations plus a d suffix for days
+func parseSince(since string) (time.Duration, error) {
+	if strings.HasSuffix(since, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(since, "d"))
+		if err != nil {
+			return 0, errors.New("Invalid window " + since)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(since)
+}
+
+// parseTime turns a window like 30d or a date (2006-01-02 or RFC 3339)
+// into milliseconds since the epoch, windows are counted back from now
+func parseTime(value string, now time.Time) (int64, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UnixNano() / int64(time.Millisecond), nil
+	}
+	window, err := parseSince(value)
+	if err != nil {
+		return 0, errors.New("Invalid time " + value + ", use a window like 30d or a date like 2006-01-02")
+	}
+	return now.Add(-window).UnixNano() / int64(time.Millisecond), nil
+}
+
+// selectBuilds loads the builds of stored jobs matching filter, started
+// after since (everything when since is empty), including pruned builds
+// that were rolled up
+func selectBuilds(store BuildStore, filter, since string) ([]Build, error) {
+	var from int64
+	if since != "" {
+		var err error
+		if from, err = parseTime(since, time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	var pattern *regexp.Regexp
+	if filter != "" {
+		var err error
+		if pattern, err = regexp.Compile(filter); err != nil {
+			return nil, err
+		}
+	}
+	jobs, err := store.GetJobs()
+	if err != nil {
+		return nil, err
+	}
+	var builds []Build
+	for _, job := range jobs {
+		if pattern != nil && !pattern.MatchString(job.Name) {
+			continue
+		}
+		jobBuilds, err := store.QueryBuilds(BuildQuery{Server: job.Server, Job: job.Name, From: from})
+		if err != nil {
+			return nil, err
+		}
+		builds = append(builds, jobBuilds...)
+	}
+	rollups, err := store.GetRollups()
+	if err != nil {
+		return nil, err
+	}
+	return append(rolledUpBuilds(rollups, pattern, from), builds...), nil
+}
+
+func percent(f float64) string {
+	return strconv.FormatFloat(100*f, 'f', 1, 64) + "%"
+}
+
+func msDuration(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).Truncate(time.Second).String()
+}
+
+func hostTable(stats []HostStats) Table {
+	t := Table{Headers: []string{"Host", "Builds", "Failure", "Unstable", "Median", "P95", "TestFailure", "Infra", "Tests", "Expected", "Score", "Flag"}}
+	for _, h := range stats {
+		flag := ""
+		if h.Flagged {
+			flag = "SUSPECT"
+		}
+		tests := "-"
+		if h.TestsTotal > 0 {
+			tests = percent(h.TestFailureRate())
+		}
+		t.Add(h.Host, h.Builds, percent(h.FailureRate()), percent(h.UnstableRate()), msDuration(h.Median), msDuration(h.P95),
+			tests, h.Infra, h.Tests, strconv.FormatFloat(h.Expected, 'f', 1, 64), strconv.FormatFloat(h.Score, 'f', 2, 64), flag)
+	}
+	return t
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func build(job, host, result string, duration int64, failed, total int) Build {
+	return Build{"http://fake/jenkins", job, 0, 1000, duration, host, result, failed, total}
+}
+
+func TestHostReport(t *testing.T) {
+	var builds []Build
+	// job1 fails 1 in 10 on good hosts but 8 in 10 on bad
+	for i := 0; i < 10; i++ {
+		good := "SUCCESS"
+		if i == 0 {
+			good = "FAILURE"
+		}
+		bad := "FAILURE"
+		if i >= 8 {
+			bad = "UNSTABLE"
+		}
+		builds = append(builds, build("job1", "good1", good, int64(i+1)*1000, 0, 10))
+		builds = append(builds, build("job1", "good2", good, 1000, 0, 10))
+		builds = append(builds, build("job1", "bad", bad, 5000, 5, 10))
+	}
+	builds = append(builds, build("job1", "failure: No Host", "SUCCESS", 1000, -1, -1))
+	builds = append(builds, build("job1", "good1", "", 1000, -1, -1))
+	stats := HostReport(builds, nil, 2)
+	if len(stats) != 4 {
+		t.Fatalf("Expected 4 hosts but got %v", stats)
+	}
+	bad := stats[0]
+	if bad.Host != "bad" || !bad.Flagged || bad.Failures != 8 || bad.Unstable != 2 || bad.Builds != 10 {
+		t.Fatalf("Expected bad host to be flagged first but got %+v", bad)
+	}
+	if bad.TestFailureRate() != 0.5 {
+		t.Fatalf("Expected half the tests to fail on bad host but got %f", bad.TestFailureRate())
+	}
+	for _, h := range stats[1:] {
+		if h.Flagged {
+			t.Fatalf("Did not expect %s to be flagged: %+v", h.Host, h)
+		}
+		if h.Host == "good1" && (h.Median != 5000 || h.P95 != 10000 || h.Builds != 10) {
+			t.Fatalf("Wrong durations for good1: %+v", h)
+		}
+	}
+}
+
+func TestWriteTableCsv(t *testing.T) {
+	table := Table{Headers: []string{"Job", "Host"}}
+	table.Add("a,b", `say "hi"`)
+	var out bytes.Buffer
+	if err := WriteTable(&out, "csv", table); err != nil {
+		t.Fatal(err.Error())
+	}
+	if out.String() != "Job,Host\n\"a,b\",\"say \"\"hi\"\"\"\n" {
+		t.Fatalf("Unexpected csv %q", out.String())
+	}
+	out.Reset()
+	WriteTable(&out, "json", table)
+	if strings.TrimSpace(out.String()) != `{"Host":"say \"hi\"","Job":"a,b"}` {
+		t.Fatalf("Unexpected json %q", out.String())
+	}
+}
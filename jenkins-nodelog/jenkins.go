@@ -1,54 +1,33 @@
 package main
 
 import (
-	"bufio"
-	"encoding/json"
 	"errors"
-	"io/ioutil"
-	"net/http"
+	"github.com/jwiklund/jenkins"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
+// Job is a tracked job, Archived is when it was found to be deleted on the
+// server (unix seconds), zero while it still exists
 type Job struct {
-	Name string
-	Url  string
+	Server   string `json:"server"`
+	Name     string `json:"name"`
+	Url      string `json:"url"`
+	Archived int64  `json:"archived"`
 }
 
 func (j Job) String() string {
 	return "Job with name " + j.Name + " at " + j.Url
 }
 
-type Jenkins struct {
-	Jobs []Job
-}
-
-func getJson(url string, target interface{}) error {
-	resp, err := http.Get(url)
+func GetJobs(j jenkins.Jenkins, filter string) ([]Job, error) {
+	all, err := j.Jobs()
 	if err != nil {
-		return errors.New("Could not fetch json: " + err.Error())
-	}
-	defer resp.Body.Close()
-	jsonBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return errors.New("Could not read json: " + err.Error())
-	}
-	err = json.Unmarshal(jsonBytes, target)
-	if err != nil {
-		return errors.New("Could not parse json: " + err.Error())
-	}
-	return nil
-}
-
-func GetJobs(filter string) ([]Job, error) {
-	var jenkins Jenkins
-	err := getJson("http://jenkins/jenkins/api/json", &jenkins)
-	if err != nil {
-		return nil, err
+		return nil, errors.New("Could not list jobs: " + err.Error())
 	}
 	var jobs []Job
-	for _, job := range jenkins.Jobs {
+	for _, job := range all {
 		if filter != "" {
 			matched, err := regexp.MatchString(filter, job.Name)
 			if err != nil {
@@ -58,40 +37,21 @@ func GetJobs(filter string) ([]Job, error) {
 				continue
 			}
 		}
-		jobs = append(jobs, job)
+		jobs = append(jobs, Job{j.Server(), job.Name, job.Url, 0})
 	}
 	return jobs, nil
 }
 
 type Build struct {
-	Job      string
-	Number   int
-	Start    int64
-	Duration int64
-	Host     string
-	Result   string
-	Failed   int
-	Total    int
-}
-
-type JsonAction struct {
-	FailCount  int
-	TotalCount int
-}
-
-type JsonBuild struct {
-	FullDisplayName string
-	Url             string
-	Duration        int64
-	Timestamp       int64
-	Number          int
-	Result          string
-	Actions         []JsonAction
-}
-
-type JsonJob struct {
-	DisplayName string
-	Builds      []JsonBuild
+	Server   string `json:"server"`
+	Job      string `json:"job"`
+	Number   int    `json:"number"`
+	Start    int64  `json:"start"`
+	Duration int64  `json:"duration"`
+	Host     string `json:"host"`
+	Result   string `json:"result"`
+	Failed   int    `json:"failed"`
+	Total    int    `json:"total"`
 }
 
 func itoa(i int64) string {
@@ -104,51 +64,81 @@ func (b Build) String() string {
 		" status " + b.Result + " failed " + strconv.Itoa(b.Failed) + " of " + strconv.Itoa(b.Total)
 }
 
-func (j Job) GetBuilds() ([]Build, error) {
-	var details JsonJob
-	err := getJson(j.Url+"/api/json?tree=builds[number,url,duration,timestamp,fullDisplayName,result,actions[failCount,totalCount]]", &details)
+func (job Job) GetBuilds(j jenkins.Jenkins) ([]Build, error) {
+	builds, err := j.JobBuilds(job.Name)
 	if err != nil {
-		return nil, errors.New("Could not fetch json: " + err.Error())
+		return nil, errors.New("Could not fetch builds: " + err.Error())
 	}
 	var res []Build
-	for _, build := range details.Builds {
-		host, err := GetHost(build.Url)
+	for _, build := range builds {
+		b, _ := job.Build(j, build, "", nil)
+		res = append(res, b)
+	}
+	return res, nil
+}
+
+const pageSize = 50
+
+// NewBuilds pages through the builds of the job, newest first, until it
+// reaches one that is already stored
+func (job Job) NewBuilds(j jenkins.Jenkins, last int) ([]jenkins.BuildInfo, error) {
+	var builds []jenkins.BuildInfo
+	for from := 0; ; from += pageSize {
+		page, err := j.JobBuildsRange(job.Name, from, from+pageSize)
 		if err != nil {
-			host = "failure: " + err.Error()
+			return nil, errors.New("Could not fetch builds: " + err.Error())
 		}
-		fail := -1
-		total := -1
-		for _, a := range build.Actions {
-			if a.TotalCount > 0 {
-				total = a.TotalCount
-				fail = a.FailCount
+		for _, build := range page {
+			if build.Number <= last {
+				return builds, nil
 			}
+			builds = append(builds, build)
+		}
+		if len(page) < pageSize {
+			return builds, nil
 		}
-		res = append(res, Build{j.Name, build.Number, build.Timestamp, build.Duration, host, build.Result, fail, total})
 	}
-	return res, nil
 }
 
-func GetHost(url string) (string, error) {
-	console, err := http.Get(url + "/consoleText")
-	if err != nil {
-		return "", err
+func knownHost(host string) bool {
+	return host != "" && !strings.HasPrefix(host, "failure: ")
+}
+
+func unsuccessful(result string) bool {
+	return result != "" && result != "SUCCESS"
+}
+
+// Build converts build info to a stored build. The host is the node
+// Jenkins built on, or from the console for builds that do not have one.
+// The console is only read when host is not already known or, with rules,
+// to find why it failed.
+func (job Job) Build(j jenkins.Jenkins, build jenkins.BuildInfo, host string, rules []CauseRule) (Build, *FailureCause) {
+	if !unsuccessful(build.Result) {
+		rules = nil
 	}
-	defer console.Body.Close()
-	r := bufio.NewReader(console.Body)
-	line, err := r.ReadString('\n')
-	for err == nil {
-		if strings.Index(line, "Node Controller:") == 0 {
-			ctrl := strings.Trim(strings.Split(line, ":")[1], " \r\n\t")
-			if ctrl == "" {
-				return "", errors.New("Empty Host")
-			}
-			if ctrl == "Host key verification failed." {
-				return "", errors.New(ctrl)
+	if !knownHost(host) && build.BuiltOn != "" {
+		host = build.BuiltOn
+	}
+	var cause *FailureCause
+	if !knownHost(host) || len(rules) > 0 {
+		scanned, found, err := ScanConsole(j, jenkins.BuildRef{Job: job.Name, Number: build.Number}, rules)
+		if !knownHost(host) {
+			host = scanned
+			if err != nil {
+				host = "failure: " + err.Error()
 			}
-			return ctrl, nil
 		}
-		line, err = r.ReadString('\n')
+		if found != nil {
+			found.Server = job.Server
+			cause = found
+		}
 	}
-	return "", errors.New("No Host")
+	fail, total := build.Tests()
+	return Build{job.Server, job.Name, build.Number, build.Timestamp, build.Duration, host, build.Result, fail, total}, cause
+}
+
+// GetHost streams the console and stops at the Node Controller: line
+func GetHost(j jenkins.Jenkins, ref jenkins.BuildRef) (string, error) {
+	host, _, err := ScanConsole(j, ref, nil)
+	return host, err
 }
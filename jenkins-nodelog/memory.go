@@ -0,0 +1,370 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+type jobKey struct {
+	server string
+	name   string
+}
+
+// MemoryStore keeps everything in maps, it is meant for tests
+type MemoryStore struct {
+	mu       sync.Mutex
+	jobs     map[jobKey]Job
+	builds   map[jobKey]map[int]Build
+	failures map[jobKey]string
+	rules    []Rule
+	retain   []Retention
+	rollups  []Rollup
+	causes   map[string]FailureCause
+	metas    map[string]BuildMeta
+	inTx     bool
+	commits  int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{jobs: make(map[jobKey]Job), builds: make(map[jobKey]map[int]Build), failures: make(map[jobKey]string),
+		causes: make(map[string]FailureCause), metas: make(map[string]BuildMeta)}
+}
+
+func (m *MemoryStore) GetJobs() ([]Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var jobs []Job
+	for _, job := range m.jobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		if jobs[a].Server != jobs[b].Server {
+			return jobs[a].Server < jobs[b].Server
+		}
+		return jobs[a].Name < jobs[b].Name
+	})
+	return jobs, nil
+}
+
+func (m *MemoryStore) GetJob(server, name string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[jobKey{server, name}]
+	if !ok {
+		return Job{}, errors.New("Job not found " + name + " on " + server)
+	}
+	return job, nil
+}
+
+func (m *MemoryStore) PutJob(job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := jobKey{job.Server, job.Name}
+	if _, ok := m.jobs[key]; ok {
+		return errors.New("Job already stored " + job.Name)
+	}
+	m.jobs[key] = job
+	return nil
+}
+
+func (m *MemoryStore) ArchiveJob(job Job, archived int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := jobKey{job.Server, job.Name}
+	stored, ok := m.jobs[key]
+	if !ok {
+		return errors.New("Job not found " + job.Name + " on " + job.Server)
+	}
+	stored.Archived = archived
+	m.jobs[key] = stored
+	return nil
+}
+
+func (m *MemoryStore) GetRules() ([]Rule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Rule(nil), m.rules...), nil
+}
+
+func (m *MemoryStore) PutRule(rule Rule) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.rules {
+		if existing.Server == rule.Server && existing.Pattern == rule.Pattern {
+			return errors.New("Rule already stored " + rule.Pattern)
+		}
+	}
+	m.rules = append(m.rules, rule)
+	return nil
+}
+
+func (m *MemoryStore) DeleteRule(server, pattern string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, rule := range m.rules {
+		if rule.Server == server && rule.Pattern == pattern {
+			m.rules = append(m.rules[0:i], m.rules[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("No rule " + pattern + " for " + server)
+}
+
+func (m *MemoryStore) GetBuilds(server, name string) ([]Build, error) {
+	return m.QueryBuilds(BuildQuery{Server: server, Job: name})
+}
+
+func (m *MemoryStore) LastBuild(server, name string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	last := 0
+	for number := range m.builds[jobKey{server, name}] {
+		if number > last {
+			last = number
+		}
+	}
+	return last, nil
+}
+
+func (m *MemoryStore) QueryBuilds(q BuildQuery) ([]Build, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var builds []Build
+	for _, numbers := range m.builds {
+		for _, build := range numbers {
+			if q.Match(build) {
+				builds = append(builds, build)
+			}
+		}
+	}
+	sort.Slice(builds, func(a, b int) bool {
+		if builds[a].Server != builds[b].Server {
+			return builds[a].Server < builds[b].Server
+		}
+		if builds[a].Job != builds[b].Job {
+			return builds[a].Job < builds[b].Job
+		}
+		return builds[a].Number < builds[b].Number
+	})
+	return builds, nil
+}
+
+func (m *MemoryStore) InsertBuild(build Build) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := jobKey{build.Server, build.Job}
+	if m.builds[key] == nil {
+		m.builds[key] = make(map[int]Build)
+	}
+	if _, ok := m.builds[key][build.Number]; ok {
+		return errors.New("Build already stored " + build.String())
+	}
+	m.builds[key][build.Number] = build
+	return nil
+}
+
+func (m *MemoryStore) UpdateBuild(build Build) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := jobKey{build.Server, build.Job}
+	if _, ok := m.builds[key][build.Number]; !ok {
+		return errors.New("Build not stored " + build.String())
+	}
+	m.builds[key][build.Number] = build
+	return nil
+}
+
+func (m *MemoryStore) DeleteBuild(build Build) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := jobKey{build.Server, build.Job}
+	if _, ok := m.builds[key][build.Number]; !ok {
+		return errors.New("Build not stored " + build.String())
+	}
+	delete(m.builds[key], build.Number)
+	delete(m.causes, causeKey(build.Server, build.Job, build.Number))
+	delete(m.metas, causeKey(build.Server, build.Job, build.Number))
+	return nil
+}
+
+func (m *MemoryStore) GetRetention() ([]Retention, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Retention(nil), m.retain...), nil
+}
+
+func (m *MemoryStore) PutRetention(rule Retention) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.retain {
+		if m.retain[i].Pattern == rule.Pattern {
+			m.retain[i] = rule
+			return nil
+		}
+	}
+	m.retain = append(m.retain, rule)
+	return nil
+}
+
+func (m *MemoryStore) DeleteRetention(pattern string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, rule := range m.retain {
+		if rule.Pattern == pattern {
+			m.retain = append(m.retain[0:i], m.retain[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("No retention rule " + pattern)
+}
+
+func (m *MemoryStore) GetRollups() ([]Rollup, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Rollup(nil), m.rollups...), nil
+}
+
+func (m *MemoryStore) AddRollup(r Rollup) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, other := range m.rollups {
+		if other.Server == r.Server && other.Job == r.Job && other.Host == r.Host && other.Day == r.Day && other.Result == r.Result {
+			m.rollups[i].Builds += r.Builds
+			m.rollups[i].Duration += r.Duration
+			return nil
+		}
+	}
+	m.rollups = append(m.rollups, r)
+	return nil
+}
+
+func (m *MemoryStore) GetCauses() ([]FailureCause, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var causes []FailureCause
+	for _, c := range m.causes {
+		causes = append(causes, c)
+	}
+	sort.Slice(causes, func(a, b int) bool {
+		if causes[a].Server != causes[b].Server {
+			return causes[a].Server < causes[b].Server
+		}
+		if causes[a].Job != causes[b].Job {
+			return causes[a].Job < causes[b].Job
+		}
+		return causes[a].Number < causes[b].Number
+	})
+	return causes, nil
+}
+
+func (m *MemoryStore) PutCause(c FailureCause) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.causes[causeKey(c.Server, c.Job, c.Number)] = c
+	return nil
+}
+
+func (m *MemoryStore) GetMetas(server, name string) ([]BuildMeta, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var metas []BuildMeta
+	for _, meta := range m.metas {
+		if meta.Server == server && meta.Job == name {
+			metas = append(metas, meta)
+		}
+	}
+	sort.Slice(metas, func(a, b int) bool { return metas[a].Number < metas[b].Number })
+	return metas, nil
+}
+
+func (m *MemoryStore) PutMeta(meta BuildMeta) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := causeKey(meta.Server, meta.Job, meta.Number)
+	if meta.Stages == nil {
+		meta.Stages = m.metas[key].Stages
+	}
+	m.metas[key] = meta
+	return nil
+}
+
+func (m *MemoryStore) Vacuum() error {
+	return nil
+}
+
+func (m *MemoryStore) LogFailure(job Job, message string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures[jobKey{job.Server, job.Name}] = message
+	return nil
+}
+
+func (m *MemoryStore) ClearFailure(job Job) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failures, jobKey{job.Server, job.Name})
+	return nil
+}
+
+func (m *MemoryStore) FailedJobs() ([]Job, error) {
+	jobs, err := m.GetJobs()
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var failed []Job
+	for _, job := range jobs {
+		if _, ok := m.failures[jobKey{job.Server, job.Name}]; ok {
+			failed = append(failed, job)
+		}
+	}
+	return failed, nil
+}
+
+func (m *MemoryStore) Begin() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.inTx {
+		return errors.New("transaction already in progress")
+	}
+	m.inTx = true
+	return nil
+}
+
+func (m *MemoryStore) Commit() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.inTx {
+		return errors.New("No transaction inprogress")
+	}
+	m.inTx = false
+	m.commits++
+	return nil
+}
+
+func (m *MemoryStore) Lock() error {
+	return nil
+}
+
+func (m *MemoryStore) Info() (StoreInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info := StoreInfo{Version: migrations[len(migrations)-1].version, Jobs: len(m.jobs)}
+	for _, numbers := range m.builds {
+		for _, build := range numbers {
+			info.Builds++
+			if info.First == 0 || build.Start < info.First {
+				info.First = build.Start
+			}
+			if build.Start > info.Last {
+				info.Last = build.Start
+			}
+		}
+	}
+	return info, nil
+}
+
+func (m *MemoryStore) Close() {
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"github.com/jwiklund/jenkins"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BuildMeta is what Jenkins knows about a build besides its result: the
+// node it ran on, why it started, its parameters and the changes in it.
+// Stages are only recorded for pipelines when refreshing with -stages,
+// they are nil when not fetched and PutMeta then keeps the stored ones.
+type BuildMeta struct {
+	Server         string            `json:"server"`
+	Job            string            `json:"job"`
+	Number         int               `json:"number"`
+	BuiltOn        string            `json:"builtOn"`
+	Causes         []BuildCause      `json:"causes"`
+	Parameters     map[string]string `json:"parameters"`
+	Changes        []Change          `json:"changes"`
+	Culprits       []string          `json:"culprits"`
+	UpstreamJob    string            `json:"upstreamJob"`
+	UpstreamNumber int               `json:"upstreamNumber"`
+	Stages         []Stage           `json:"stages"`
+}
+
+// Stage is where and for how long a pipeline stage ran, Host is empty for
+// the built-in node
+type Stage struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Host     string `json:"host"`
+	Start    int64  `json:"start"`
+	Duration int64  `json:"duration"`
+}
+
+// BuildCause Kind is timer, scm, upstream, user or other
+type BuildCause struct {
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	User        string `json:"user"`
+}
+
+type Change struct {
+	Commit  string `json:"commit"`
+	Author  string `json:"author"`
+	Message string `json:"message"`
+}
+
+// Meta collects the metadata of build, culprits are the authors Jenkins
+// blames followed by any other changeset authors
+func (job Job) Meta(build jenkins.BuildInfo) BuildMeta {
+	meta := BuildMeta{Server: job.Server, Job: job.Name, Number: build.Number, BuiltOn: build.BuiltOn, Parameters: build.Parameters()}
+	meta.UpstreamJob, meta.UpstreamNumber = build.Upstream()
+	for _, c := range build.Causes() {
+		meta.Causes = append(meta.Causes, BuildCause{c.Kind(), c.ShortDescription, c.UserId})
+	}
+	seen := make(map[string]bool)
+	culprit := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			meta.Culprits = append(meta.Culprits, name)
+		}
+	}
+	for _, u := range build.Culprits {
+		culprit(u.FullName)
+	}
+	for _, c := range build.Changes() {
+		meta.Changes = append(meta.Changes, Change{c.CommitId, c.Author.FullName, c.Msg})
+		culprit(c.Author.FullName)
+	}
+	return meta
+}
+
+// Stages fetches the stages of a pipeline build, nil when they could not
+// be fetched
+func (job Job) Stages(j jenkins.Jenkins, build jenkins.BuildInfo) []Stage {
+	stages, err := j.Stages(jenkins.BuildRef{Job: job.Name, Number: build.Number})
+	if err != nil {
+		return nil
+	}
+	res := []Stage{}
+	for _, s := range stages {
+		res = append(res, Stage{s.Name, s.Status, s.Node, s.Start, s.Duration})
+	}
+	return res
+}
+
+// firstLine keeps the summary of a commit message
+func firstLine(msg string) string {
+	if ind := strings.IndexByte(msg, '\n'); ind != -1 {
+		return msg[0:ind]
+	}
+	return msg
+}
+
+// ChangesReport lists the commits in the builds matching the query, such
+// as the changes that landed in builds that failed on a host
+func ChangesReport(store BuildStore, expr string, format string) {
+	query, err := ParseQuery(expr, time.Now())
+	if err != nil {
+		fmt.Println("Invalid query ", err)
+		return
+	}
+	builds, err := query.Run(store)
+	if err != nil {
+		fmt.Println("Could not load builds ", err)
+		return
+	}
+	metas := make(map[jobKey]map[int]BuildMeta)
+	t := Table{Headers: []string{"Job", "Number", "Host", "Result", "Start", "Commit", "Author", "Message"}}
+	sort.SliceStable(builds, func(a, b int) bool { return builds[a].Start < builds[b].Start })
+	for _, b := range builds {
+		key := jobKey{b.Server, b.Job}
+		if _, ok := metas[key]; !ok {
+			stored, err := store.GetMetas(b.Server, b.Job)
+			if err != nil {
+				fmt.Println("Could not load metadata of "+b.Job, err)
+				return
+			}
+			metas[key] = make(map[int]BuildMeta)
+			for _, meta := range stored {
+				metas[key][meta.Number] = meta
+			}
+		}
+		for _, c := range metas[key][b.Number].Changes {
+			t.Add(b.Job, b.Number, b.Host, b.Result, formatStart(b.Start), c.Commit, c.Author, firstLine(c.Message))
+		}
+	}
+	if err := WriteTable(os.Stdout, format, t); err != nil {
+		fmt.Println("Could not write report ", err)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"github.com/jwiklund/jenkins"
+	"reflect"
+	"testing"
+)
+
+func TestRefreshMeta(t *testing.T) {
+	j := newFake()
+	build := &j.builds["job1"][0]
+	build.BuiltOn = "agent7"
+	build.Actions = append(build.Actions, jenkins.BuildAction{
+		Causes:     []jenkins.BuildCause{{Class: "hudson.model.Cause$UpstreamCause", ShortDescription: "Started by upstream", UpstreamProject: "compile", UpstreamBuild: 9}},
+		Parameters: []jenkins.BuildParameter{{Name: "BRANCH", Value: "main"}, {Name: "FAST", Value: false}},
+	})
+	build.ChangeSets = []jenkins.ChangeSet{{Items: []jenkins.Change{
+		{CommitId: "abc", Msg: "Fix it\n\nfor real", Author: jenkins.User{FullName: "Ada"}},
+		{CommitId: "def", Msg: "Again", Author: jenkins.User{FullName: "Grace"}},
+	}}}
+	build.Culprits = []jenkins.User{{FullName: "Grace"}}
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1"})
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{})
+	builds, _ := store.GetBuilds("http://fake/jenkins", "job1")
+	if builds[1].Host != "agent7" || builds[0].Host != "host1" {
+		t.Fatalf("Expected the node Jenkins built on before the console host but got %+v", builds)
+	}
+	if j.reads["job1#2"] != 0 {
+		t.Fatalf("Did not expect the console of a build with a node to be read")
+	}
+	metas, _ := store.GetMetas("http://fake/jenkins", "job1")
+	if len(metas) != 2 {
+		t.Fatalf("Expected metadata for both builds but got %+v", metas)
+	}
+	expected := BuildMeta{
+		Server: "http://fake/jenkins", Job: "job1", Number: 2, BuiltOn: "agent7",
+		Causes:      []BuildCause{{"upstream", "Started by upstream", ""}},
+		Parameters:  map[string]string{"BRANCH": "main", "FAST": "false"},
+		Changes:     []Change{{"abc", "Ada", "Fix it\n\nfor real"}, {"def", "Grace", "Again"}},
+		Culprits:    []string{"Grace", "Ada"},
+		UpstreamJob: "compile", UpstreamNumber: 9,
+	}
+	if !reflect.DeepEqual(metas[1], expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, metas[1])
+	}
+
+	sqlStore := openFixture(t, fixtureStore(t, ""))
+	defer sqlStore.Close()
+	if _, err := CopyStore(store, sqlStore); err != nil {
+		t.Fatal(err.Error())
+	}
+	stored, err := sqlStore.GetMetas("http://fake/jenkins", "job1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(stored) != 2 || !reflect.DeepEqual(stored[1], expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, stored)
+	}
+	if err := sqlStore.DeleteBuild(builds[1]); err != nil {
+		t.Fatal(err.Error())
+	}
+	if stored, _ = sqlStore.GetMetas("http://fake/jenkins", "job1"); len(stored) != 1 {
+		t.Fatalf("Expected the metadata to go with the build but got %+v", stored)
+	}
+}
+
+func TestRefreshStages(t *testing.T) {
+	j := newFake()
+	j.builds["job1"][0].Class = "org.jenkinsci.plugins.workflow.job.WorkflowRun"
+	j.stages = map[string][]jenkins.Stage{"job1#2": {
+		{Id: "6", Name: "Build", Status: "SUCCESS", Start: 2000, Duration: 5},
+		{Id: "17", Name: "Test", Status: "FAILED", Node: "agent3", Start: 2005, Duration: 15},
+	}, "job1#1": {{Id: "6", Name: "Build", Status: "SUCCESS", Start: 1000, Duration: 5}}}
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1"})
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{Stages: true})
+	metas, _ := store.GetMetas("http://fake/jenkins", "job1")
+	expected := []Stage{{"Build", "SUCCESS", "", 2000, 5}, {"Test", "FAILED", "agent3", 2005, 15}}
+	if len(metas) != 2 || metas[0].Stages != nil || !reflect.DeepEqual(metas[1].Stages, expected) {
+		t.Fatalf("Expected stages of the pipeline build only but got %+v", metas)
+	}
+	// a refresh without -stages must not drop the recorded stages
+	store.PutMeta(BuildMeta{Server: "http://fake/jenkins", Job: "job1", Number: 2, BuiltOn: "host2"})
+	if metas, _ = store.GetMetas("http://fake/jenkins", "job1"); !reflect.DeepEqual(metas[1].Stages, expected) {
+		t.Fatalf("Expected the stages to be kept but got %+v", metas[1])
+	}
+	sqlStore := openFixture(t, fixtureStore(t, ""))
+	defer sqlStore.Close()
+	if _, err := CopyStore(store, sqlStore); err != nil {
+		t.Fatal(err.Error())
+	}
+	stored, err := sqlStore.GetMetas("http://fake/jenkins", "job1")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(stored) != 2 || !reflect.DeepEqual(stored[1].Stages, expected) {
+		t.Fatalf("Expected %+v but got %+v", expected, stored)
+	}
+	sqlStore.PutMeta(BuildMeta{Server: "http://fake/jenkins", Job: "job1", Number: 2, BuiltOn: "host2"})
+	if stored, _ = sqlStore.GetMetas("http://fake/jenkins", "job1"); !reflect.DeepEqual(stored[1].Stages, expected) || stored[1].BuiltOn != "host2" {
+		t.Fatalf("Expected the stages to be kept but got %+v", stored[1])
+	}
+	sqlStore.PutMeta(BuildMeta{Server: "http://fake/jenkins", Job: "job1", Number: 2, Stages: []Stage{}})
+	if stored, _ = sqlStore.GetMetas("http://fake/jenkins", "job1"); stored[1].Stages != nil {
+		t.Fatalf("Expected fetched empty stages to replace the stored ones but got %+v", stored[1])
+	}
+}
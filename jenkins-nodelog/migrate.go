@@ -0,0 +1,242 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A migration takes the store from version-1 to version. Every migration
+// runs in its own transaction and is recorded in the migrations table.
+type migration struct {
+	version int
+	name    string
+	stmts   []string
+}
+
+// legacyServer is used for stored jobs whose url does not tell which
+// server they came from, before version 3 that was always this one
+const legacyServer = "http://jenkins/jenkins"
+
+var migrations = []migration{
+	{1, "create builds and jobs", []string{
+		"create table if not exists builds(job, number, start, duration, host, result, primary key(job, number))",
+		"create table if not exists jobs(name primary key, url)",
+	}},
+	{2, "add test counts", []string{
+		"alter table builds add column failed",
+		"alter table builds add column total",
+	}},
+	{3, "add server to jobs and builds", []string{
+		"create table jobs_v3(server, name, url, primary key(server, name))",
+		"insert into jobs_v3 select case when instr(url, '/job/') > 0 then substr(url, 1, instr(url, '/job/') - 1) else '" + legacyServer + "' end, name, url from jobs",
+		"create table builds_v3(server, job, number, start, duration, host, result, failed, total, primary key(server, job, number))",
+		"insert into builds_v3 select coalesce((select server from jobs_v3 where jobs_v3.name = builds.job), '" + legacyServer + "'), job, number, start, duration, host, result, failed, total from builds",
+		"drop table jobs",
+		"alter table jobs_v3 rename to jobs",
+		"drop table builds",
+		"alter table builds_v3 rename to builds",
+	}},
+	{4, "typed columns and indexes", []string{
+		"create table jobs_v4(server text not null, name text not null, url text not null default '', primary key(server, name))",
+		"insert into jobs_v4 select server, name, coalesce(url, '') from jobs",
+		"create table builds_v4(server text not null, job text not null, number integer not null, start integer, duration integer, " +
+			"host text, result text, failed integer, total integer, primary key(server, job, number))",
+		"insert into builds_v4 select server, job, cast(number as integer), cast(start as integer), cast(duration as integer), " +
+			"host, result, cast(failed as integer), cast(total as integer) from builds",
+		"drop table jobs",
+		"alter table jobs_v4 rename to jobs",
+		"drop table builds",
+		"alter table builds_v4 rename to builds",
+		"create index builds_job on builds(job)",
+		"create index builds_host on builds(host)",
+		"create index builds_start on builds(start)",
+	}},
+	{5, "add refresh log", []string{
+		"create table refresh_log(server text not null, job text not null, time integer not null, error text not null, primary key(server, job))",
+	}},
+	{6, "add tracking rules and archived jobs", []string{
+		"create table rules(server text not null, pattern text not null, exclude integer not null, primary key(server, pattern))",
+		"alter table jobs add column archived integer not null default 0",
+	}},
+	{7, "add retention rules and rollups", []string{
+		"create table retention(pattern text primary key, position integer not null, days integer not null, builds integer not null, failure_days integer not null)",
+		"create table rollups(server text not null, job text not null, host text not null, day text not null, result text not null, " +
+			"builds integer not null, duration integer not null, primary key(server, job, host, day, result))",
+	}},
+	{8, "add failure causes", []string{
+		"create table failure_causes(server text not null, job text not null, number integer not null, " +
+			"category text not null, cause text not null, excerpt text not null, primary key(server, job, number))",
+	}},
+	{9, "add build metadata", []string{
+		"create table build_meta(server text not null, job text not null, number integer not null, " +
+			"built_on text not null, upstream_job text not null, upstream_number integer not null, primary key(server, job, number))",
+		"create table build_causes(server text not null, job text not null, number integer not null, position integer not null, " +
+			"kind text not null, description text not null, user_id text not null, primary key(server, job, number, position))",
+		"create table build_parameters(server text not null, job text not null, number integer not null, " +
+			"name text not null, value text not null, primary key(server, job, number, name))",
+		"create table build_changes(server text not null, job text not null, number integer not null, position integer not null, " +
+			"commit_id text not null, author text not null, message text not null, primary key(server, job, number, position))",
+		"create table build_culprits(server text not null, job text not null, number integer not null, position integer not null, " +
+			"name text not null, primary key(server, job, number, position))",
+		"create index build_changes_author on build_changes(author)",
+	}},
+	{10, "add pipeline stages", []string{
+		"create table build_stages(server text not null, job text not null, number integer not null, position integer not null, " +
+			"name text not null, status text not null, host text not null, start integer not null, duration integer not null, primary key(server, job, number, position))",
+		"create index build_stages_host on build_stages(host)",
+	}},
+}
+
+func (s SQLStore) hasTable(name string) (bool, error) {
+	var count int
+	err := s.db.QueryRow("select count(*) from sqlite_master where type = 'table' and name = ?", name).Scan(&count)
+	return count > 0, err
+}
+
+func (s SQLStore) hasColumn(table, column string) (bool, error) {
+	rows, err := s.db.Query("pragma table_info(" + table + ")")
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+	values := make([]interface{}, len(columns))
+	for i := range values {
+		values[i] = new(sql.RawBytes)
+	}
+	for rows.Next() {
+		if err := rows.Scan(values...); err != nil {
+			return false, err
+		}
+		if string(*values[1].(*sql.RawBytes)) == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// legacyVersion works out the version of a store from before the
+// migrations table, the version table was not always filled in so the
+// columns are the only reliable source.
+func (s SQLStore) legacyVersion() (int, error) {
+	builds, err := s.hasTable("builds")
+	if err != nil || !builds {
+		return 0, err
+	}
+	if server, err := s.hasColumn("builds", "server"); err != nil || server {
+		return 3, err
+	}
+	if failed, err := s.hasColumn("builds", "failed"); err != nil || failed {
+		return 2, err
+	}
+	return 1, nil
+}
+
+func (s SQLStore) version() (int, error) {
+	var version sql.NullInt64
+	if err := s.db.QueryRow("select max(version) from migrations").Scan(&version); err != nil {
+		return -1, err
+	}
+	return int(version.Int64), nil
+}
+
+// bootstrap creates the migrations table, recording what an older store
+// already has so those migrations are not run again.
+func (s SQLStore) bootstrap() error {
+	if s.driver == "postgres" {
+		_, err := s.db.Exec("create table if not exists migrations(version integer primary key, name text not null, applied bigint not null)")
+		return err
+	}
+	exists, err := s.hasTable("migrations")
+	if err != nil || exists {
+		return err
+	}
+	legacy, err := s.legacyVersion()
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("create table migrations(version integer primary key, name text not null, applied integer not null)"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, m := range migrations {
+		if m.version > legacy {
+			break
+		}
+		if _, err := tx.Exec("insert into migrations values (?, ?, ?)", m.version, m.name+" (existing)", time.Now().Unix()); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Exec("drop table if exists version"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s SQLStore) apply(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, stmt := range m.stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return errors.New("Migration " + strconv.Itoa(m.version) + " (" + m.name + ") failed on '" +
+				strings.SplitN(stmt, "(", 2)[0] + "': " + err.Error())
+		}
+	}
+	if _, err := tx.Exec(s.rebind("insert into migrations values (?, ?, ?)"), m.version, m.name, time.Now().Unix()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrate brings the store to the latest version holding the writer lock,
+// so two processes opening an old store don't both migrate it. Stores that
+// are already up to date are not locked, readers don't wait for writers.
+func (s SQLStore) migrate() error {
+	list := migrations
+	if s.driver == "postgres" {
+		list = postgresMigrations
+	}
+	if version, err := s.version(); err == nil && version == list[len(list)-1].version {
+		return nil
+	}
+	unlock, err := s.lockMigrations()
+	if err != nil {
+		return errors.New("Could not lock the store to migrate it: " + err.Error())
+	}
+	defer unlock()
+	if err := s.bootstrap(); err != nil {
+		return errors.New("Could not initialize migrations: " + err.Error())
+	}
+	version, err := s.version()
+	if err != nil {
+		return err
+	}
+	if version > list[len(list)-1].version {
+		return errors.New("Store version " + strconv.Itoa(version) + " is newer than this program supports")
+	}
+	for _, m := range list {
+		if m.version <= version {
+			continue
+		}
+		if err := s.apply(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"compress/bzip2"
+	"database/sql"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func fixtureStore(t *testing.T, script string) string {
+	path := filepath.Join(t.TempDir(), "nodelog.db")
+	if script == "" {
+		return path
+	}
+	sqlScript, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer db.Close()
+	if _, err := db.Exec(string(sqlScript)); err != nil {
+		t.Fatalf("Could not load %s: %s", script, err.Error())
+	}
+	return path
+}
+
+func openFixture(t *testing.T, path string) *SQLStore {
+	store, err := OpenSQLite(path)
+	if err != nil {
+		t.Fatalf("Could not open store: %s", err.Error())
+	}
+	version, err := store.version()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if version != migrations[len(migrations)-1].version {
+		t.Fatalf("Expected latest version but got %d", version)
+	}
+	var applied int
+	if err := store.db.QueryRow("select count(*) from migrations where applied > 0").Scan(&applied); err != nil {
+		t.Fatal(err.Error())
+	}
+	if applied != len(migrations) {
+		t.Fatalf("Expected %d recorded migrations but got %d", len(migrations), applied)
+	}
+	for _, index := range []string{"builds_job", "builds_host", "builds_start"} {
+		var count int
+		store.db.QueryRow("select count(*) from sqlite_master where type = 'index' and name = ?", index).Scan(&count)
+		if count != 1 {
+			t.Fatalf("Missing index %s", index)
+		}
+	}
+	return store
+}
+
+func checkStoredBuild(t *testing.T, store BuildStore, server, job string, number int, expected Build) {
+	builds, err := store.GetBuilds(server, job)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, build := range builds {
+		if build.Number == number {
+			if build != expected {
+				t.Fatalf("Expected %+v but got %+v", expected, build)
+			}
+			return
+		}
+	}
+	t.Fatalf("Build %s %d not found on %s", job, number, server)
+}
+
+func TestMigrateEmpty(t *testing.T) {
+	store := openFixture(t, fixtureStore(t, ""))
+	defer store.Close()
+	if err := store.PutJob(Job{"http://localhost/jenkins", "job", "http://localhost/jenkins/job/job/", 0}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := store.InsertBuild(Build{"http://localhost/jenkins", "job", 1, 1000, 10, "host", "SUCCESS", 0, 1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	checkStoredBuild(t, store, "http://localhost/jenkins", "job", 1, Build{"http://localhost/jenkins", "job", 1, 1000, 10, "host", "SUCCESS", 0, 1})
+}
+
+func TestMigrateV1(t *testing.T) {
+	f, err := os.Open("archived-data/data.v1.bz2")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer f.Close()
+	path := fixtureStore(t, "")
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := io.Copy(out, bzip2.NewReader(f)); err != nil {
+		t.Fatal(err.Error())
+	}
+	out.Close()
+	store := openFixture(t, path)
+	defer store.Close()
+	info, err := store.Info()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if info.Jobs != 33 || info.Builds != 173 {
+		t.Fatalf("Expected 33 jobs and 173 builds but got %+v", info)
+	}
+	checkStoredBuild(t, store, "http://jenkins.polopoly.com/jenkins", "master_Nightly-manual-external-fork-nitro-webapps-jboss-mysql-tomcat", 10,
+		Build{"http://jenkins.polopoly.com/jenkins", "master_Nightly-manual-external-fork-nitro-webapps-jboss-mysql-tomcat", 10, 1359112799000, 338223, "prodnc15", "FAILURE", -1, -1})
+}
+
+func TestMigrateV2(t *testing.T) {
+	store := openFixture(t, fixtureStore(t, "migrate_v2_test.sql"))
+	defer store.Close()
+	checkStoredBuild(t, store, "http://jenkins.polopoly.com/jenkins", "VOID_Minutely", 1045,
+		Build{"http://jenkins.polopoly.com/jenkins", "VOID_Minutely", 1045, 1381912345678, 134000, "prodnc15", "SUCCESS", 0, 812})
+	checkStoredBuild(t, store, "http://jenkins.polopoly.com/jenkins", "VOID_Minutely", 1046,
+		Build{"http://jenkins.polopoly.com/jenkins", "VOID_Minutely", 1046, 1381912545678, 12000, "failure: No Host", "FAILURE", -1, -1})
+	checkStoredBuild(t, store, legacyServer, "T2-Extra_Minutely", 454,
+		Build{legacyServer, "T2-Extra_Minutely", 454, 1381912000000, 1560000, "prodnc16", "UNSTABLE", 3, 1200})
+}
+
+func TestMigrateV3(t *testing.T) {
+	store := openFixture(t, fixtureStore(t, "migrate_v3_test.sql"))
+	defer store.Close()
+	checkStoredBuild(t, store, "http://other/jenkins", "VOID_Minutely", 1045,
+		Build{"http://other/jenkins", "VOID_Minutely", 1045, 1381912345999, 1000, "other1", "FAILURE", -1, -1})
+	jobs, err := store.GetJobs()
+	if err != nil || len(jobs) != 2 {
+		t.Fatalf("Expected two jobs but got %v %v", jobs, err)
+	}
+}
+
+func TestMigrateNewer(t *testing.T) {
+	path := fixtureStore(t, "")
+	store := openFixture(t, path)
+	store.db.Exec("insert into migrations values (999, 'future', 1)")
+	store.Close()
+	if _, err := OpenSQLite(path); err == nil {
+		t.Fatal("Expected error for newer store")
+	}
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	path := fixtureStore(t, "migrate_v2_test.sql")
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() {
+			store, err := OpenSQLite(path)
+			if err == nil {
+				store.Close()
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Expected every open to wait for the migration but got %s", err.Error())
+		}
+	}
+	openFixture(t, path).Close()
+}
@@ -0,0 +1,9 @@
+create table version(version);
+insert into version values ('2');
+create table builds(job, number, start, duration, host, result, failed, total, primary key(job, number));
+create table jobs(name primary key, url);
+insert into jobs values ('VOID_Minutely', 'http://jenkins.polopoly.com/jenkins/job/VOID_Minutely/');
+insert into jobs values ('T2-Extra_Minutely', 'T2-Extra_Minutely');
+insert into builds values ('VOID_Minutely', '1045', '1381912345678', '134000', 'prodnc15', 'SUCCESS', '0', '812');
+insert into builds values ('VOID_Minutely', '1046', '1381912545678', '12000', 'failure: No Host', 'FAILURE', NULL, NULL);
+insert into builds values ('T2-Extra_Minutely', '454', '1381912000000', '1560000', 'prodnc16', 'UNSTABLE', '3', '1200');
@@ -0,0 +1,8 @@
+create table version(version);
+insert into version values ('3');
+create table jobs(server, name, url, primary key(server, name));
+create table builds(server, job, number, start, duration, host, result, failed, total, primary key(server, job, number));
+insert into jobs values ('http://jenkins.polopoly.com/jenkins', 'VOID_Minutely', 'http://jenkins.polopoly.com/jenkins/job/VOID_Minutely/');
+insert into jobs values ('http://other/jenkins', 'VOID_Minutely', 'http://other/jenkins/job/VOID_Minutely/');
+insert into builds values ('http://jenkins.polopoly.com/jenkins', 'VOID_Minutely', 1045, 1381912345678, 134000, 'prodnc15', 'SUCCESS', 0, 812);
+insert into builds values ('http://other/jenkins', 'VOID_Minutely', 1045, 1381912345999, 1000, 'other1', 'FAILURE', -1, -1);
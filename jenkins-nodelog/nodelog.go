@@ -1,16 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"regexp"
+	"github.com/jwiklund/jenkins"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
-var storeLocation = "data"
-
-func ListJobs(filter string) {
-	jobs, err := GetJobs(filter)
+func ListJobs(j jenkins.Jenkins, filter string) {
+	jobs, err := GetJobs(j, filter)
 	if err != nil {
 		fmt.Println("Could not list jobs ", err)
 		return
@@ -20,24 +26,18 @@ func ListJobs(filter string) {
 	}
 }
 
-func SaveJobs(args []string) {
-	jobs, err := GetJobs("")
+func SaveJobs(j jenkins.Jenkins, store BuildStore, args []string) {
+	jobs, err := GetJobs(j, "")
 	if err != nil {
 		fmt.Println("Could not list jobs ", err)
 		return
 	}
-	store, err := OpenStore(storeLocation)
-	if err != nil {
-		fmt.Println("Could not open store ", err)
-		return
-	}
-	defer store.Close()
 	for _, arg := range args {
 		found := false
 		for _, job := range jobs {
 			if arg == job.Name {
 				found = true
-				_, err = store.GetJob(job.Name)
+				_, err = store.GetJob(job.Server, job.Name)
 				if err != nil {
 					err = store.PutJob(job)
 					fmt.Println("Added job "+job.Name, err)
@@ -52,8 +52,8 @@ func SaveJobs(args []string) {
 	}
 }
 
-func GetBuilds(jobNames []string) {
-	jobs, err := GetJobs("")
+func GetBuilds(j jenkins.Jenkins, jobNames []string) {
+	jobs, err := GetJobs(j, "")
 	if err != nil {
 		fmt.Println("Could not list jobs ", err)
 		return
@@ -61,7 +61,7 @@ func GetBuilds(jobNames []string) {
 	for _, jobName := range jobNames {
 		for _, job := range jobs {
 			if job.Name == jobName {
-				builds, err := job.GetBuilds()
+				builds, err := job.GetBuilds(j)
 				if err != nil {
 					fmt.Println("Could not get builds for "+jobName+" ", err)
 					continue
@@ -74,26 +74,72 @@ func GetBuilds(jobNames []string) {
 	}
 }
 
+// PutReq stores a build with its metadata and, when Cause is set, why it
+// failed
 type PutReq struct {
 	Build  Build
 	Update bool
+	Cause  *FailureCause
+	Meta   BuildMeta
+}
+
+// JobState is what refresh needs to know about a job in the store
+type JobState struct {
+	Last    int
+	Running []Build
 }
 
 type GetReq struct {
-	Name   string
-	Builds chan []Build
+	Job   Job
+	State chan JobState
+}
+
+// LogReq records the outcome of refreshing a job, an empty Error clears an
+// earlier failure
+type LogReq struct {
+	Job   Job
+	Error string
 }
 
-func StoreHandler(store *Store, puts chan *PutReq, gets chan *GetReq, fini chan bool) {
+// RefreshOptions controls RefreshBuilds, Batch is the number of builds
+// written between commits and Parallel the number of jobs refreshed at once.
+// Causes classifies the consoles of builds that did not succeed and Stages
+// records the stages of pipeline builds.
+type RefreshOptions struct {
+	Update      bool
+	RetryFailed bool
+	Batch       int
+	Parallel    int
+	// ArchiveMissing archives missing jobs even when the job list is empty
+	// or most tracked jobs are missing
+	ArchiveMissing bool
+	Causes         []CauseRule
+	Stages         bool
+}
+
+func StoreHandler(store BuildStore, batch int, puts chan *PutReq, gets chan *GetReq, logs chan *LogReq, fini chan bool) {
 	if err := store.Begin(); err != nil {
 		fmt.Println("Begin failure", err)
 	}
+	pending := 0
+	commit := func() {
+		if err := store.Commit(); err != nil {
+			fmt.Println("Commit failure", err)
+		}
+		pending = 0
+	}
 	doget := func(get *GetReq) {
-		builds, err := store.GetBuilds(get.Name)
+		var state JobState
+		var err error
+		state.Last, err = store.LastBuild(get.Job.Server, get.Job.Name)
 		if err != nil {
-			fmt.Println("Failed getting "+get.Name, err)
+			fmt.Println("Failed getting "+get.Job.Name, err)
 		}
-		get.Builds <- builds
+		state.Running, err = store.QueryBuilds(BuildQuery{Server: get.Job.Server, Job: get.Job.Name, Running: true})
+		if err != nil {
+			fmt.Println("Failed getting "+get.Job.Name, err)
+		}
+		get.State <- state
 	}
 	doput := func(put *PutReq) {
 		if put.Update {
@@ -103,115 +149,269 @@ func StoreHandler(store *Store, puts chan *PutReq, gets chan *GetReq, fini chan
 			err := store.InsertBuild(put.Build)
 			fmt.Println("Added build "+put.Build.String(), err)
 		}
+		if err := store.PutMeta(put.Meta); err != nil {
+			fmt.Println("Could not store metadata of "+put.Build.String(), err)
+		}
+		if put.Cause != nil {
+			if err := store.PutCause(*put.Cause); err != nil {
+				fmt.Println("Could not store cause of "+put.Build.String(), err)
+			}
+		}
+		pending++
+		if batch > 0 && pending >= batch {
+			commit()
+			if err := store.Begin(); err != nil {
+				fmt.Println("Begin failure", err)
+			}
+		}
 	}
-	run := true
-	for run {
+	dolog := func(log *LogReq) {
+		var err error
+		if log.Error == "" {
+			err = store.ClearFailure(log.Job)
+		} else {
+			err = store.LogFailure(log.Job, log.Error)
+		}
+		if err != nil {
+			fmt.Println("Could not log refresh of "+log.Job.Name, err)
+		}
+	}
+	for gets != nil || puts != nil || logs != nil {
 		select {
 		case get, ok := <-gets:
 			if ok {
 				doget(get)
 			} else {
-				run = false
+				gets = nil
 			}
 		case put, ok := <-puts:
 			if ok {
 				doput(put)
 			} else {
-				run = false
+				puts = nil
+			}
+		case log, ok := <-logs:
+			if ok {
+				dolog(log)
+			} else {
+				logs = nil
 			}
 		}
 	}
-	for get := range gets {
-		doget(get)
+	commit()
+	close(fini)
+}
+
+func (job Job) meta(j jenkins.Jenkins, build jenkins.BuildInfo, opts RefreshOptions) BuildMeta {
+	meta := job.Meta(build)
+	// only pipelines have stages, asking for others would waste requests
+	if opts.Stages && build.Pipeline() {
+		meta.Stages = job.Stages(j, build)
+	}
+	return meta
+}
+
+// refreshJob stores the builds that are new since the last refresh, oldest
+// first so an interrupted refresh can continue from the last stored build
+func refreshJob(ctx context.Context, j jenkins.Jenkins, job Job, opts RefreshOptions, puts chan *PutReq, gets chan *GetReq, p *progress) error {
+	getreq := GetReq{job, make(chan JobState)}
+	gets <- &getreq
+	state := <-getreq.State
+	builds, err := job.NewBuilds(j, state.Last)
+	if err != nil {
+		return err
+	}
+	for i := len(builds) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			return errors.New("Interrupted")
+		}
+		build, cause := job.Build(j, builds[i], "", opts.Causes)
+		puts <- &PutReq{build, false, cause, job.meta(j, builds[i], opts)}
+		p.build()
+	}
+	if !opts.Update {
+		return nil
 	}
-	for put := range puts {
-		doput(put)
+	var failed []string
+	for _, running := range state.Running {
+		if ctx.Err() != nil {
+			return errors.New("Interrupted")
+		}
+		build, err := j.BuildInfo(jenkins.BuildRef{Job: job.Name, Number: running.Number})
+		if err != nil {
+			failed = append(failed, "#"+strconv.Itoa(running.Number)+": "+err.Error())
+			continue
+		}
+		updated, cause := job.Build(j, build, running.Host, opts.Causes)
+		puts <- &PutReq{updated, true, cause, job.meta(j, build, opts)}
+		p.build()
 	}
-	if err := store.Commit(); err != nil {
-		fmt.Println("Commit failure", err)
+	if len(failed) > 0 {
+		return errors.New("Could not update " + strings.Join(failed, ", "))
 	}
-	close(fini)
+	return nil
 }
 
-func RefreshBuilds(update bool) {
-	store, err := OpenStore(storeLocation)
+// RefreshBuilds fetches new builds for the stored jobs. When ctx is
+// cancelled the jobs stop at the next build and what has been fetched is
+// committed, every job that did not finish is recorded in the refresh log.
+func RefreshBuilds(ctx context.Context, j jenkins.Jenkins, store BuildStore, opts RefreshOptions) {
+	var jobs []Job
+	var err error
+	if !opts.RetryFailed {
+		if err := SyncJobs(j, store, opts.ArchiveMissing); err != nil {
+			fmt.Println("Could not sync jobs ", err)
+		}
+	}
+	if opts.RetryFailed {
+		jobs, err = store.FailedJobs()
+	} else {
+		jobs, err = store.GetJobs()
+	}
 	if err != nil {
-		fmt.Println("Could not open store ", err)
+		fmt.Println("Could not load jobs ", err)
 		return
 	}
-	defer store.Close()
-	jobs, err := store.GetJobs()
+	var todo []Job
+	for _, job := range jobs {
+		if job.Server != j.Server() {
+			fmt.Println("Skipping " + job.Name + " from " + job.Server)
+			continue
+		}
+		if job.Archived != 0 {
+			continue
+		}
+		todo = append(todo, job)
+	}
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
 	var wg sync.WaitGroup
 	puts := make(chan *PutReq, 100)
 	gets := make(chan *GetReq, 100)
+	logs := make(chan *LogReq, 100)
 	fini := make(chan bool)
-	go StoreHandler(&store, puts, gets, fini)
-	for _, job := range jobs {
-		wg.Add(1)
-		f := func(job Job) {
-			defer wg.Done()
-			builds, err := job.GetBuilds()
-			if err != nil {
-				fmt.Println("Could not refresh "+job.Name+", ", err)
-				return
-			}
-			getreq := GetReq{job.Name, make(chan []Build)}
-			gets <- &getreq
-			existing := make(map[int]bool)
-			for _, build := range <-getreq.Builds {
-				existing[build.Number] = true
+	queue := make(chan Job)
+	p := newProgress(len(todo))
+	go StoreHandler(store, opts.Batch, puts, gets, logs, fini)
+	worker := func() {
+		defer wg.Done()
+		for job := range queue {
+			if ctx.Err() != nil {
+				logs <- &LogReq{job, "Interrupted"}
+				continue
 			}
-			for _, build := range builds {
-				_, ok := existing[build.Number]
-				if !ok {
-					puts <- &PutReq{build, false}
-				} else if update {
-					puts <- &PutReq{build, true}
-				}
+			if err := refreshJob(ctx, j, job, opts, puts, gets, p); err != nil {
+				fmt.Println("Could not refresh "+job.Name+", ", err)
+				logs <- &LogReq{job, err.Error()}
+			} else {
+				logs <- &LogReq{job, ""}
 			}
+			fmt.Println(p.job())
 		}
-		go f(job)
 	}
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go worker()
+	}
+	for _, job := range todo {
+		queue <- job
+	}
+	close(queue)
 	wg.Wait()
 	// clean up
 	close(puts)
 	close(gets)
+	close(logs)
 	// wait until drained
 	<-fini
 }
 
-func ExportBuilds(filter string) {
-	store, err := OpenStore(storeLocation)
+func HostsReport(store BuildStore, filter, since string, threshold float64, format string) {
+	builds, err := selectBuilds(store, filter, since)
 	if err != nil {
-		fmt.Println("Could not open store ", err)
+		fmt.Println("Could not load builds ", err)
 		return
 	}
-	defer store.Close()
-	jobs, err := store.GetJobs()
+	causes, err := store.GetCauses()
 	if err != nil {
-		fmt.Println("Could not load jobs ", err)
+		fmt.Println("Could not load failure causes ", err)
 		return
 	}
-	fmt.Println("Job,Number,Host,Duration,Start,Result,Failed,Total")
-	for _, job := range jobs {
-		if filter != "" {
-			matched, err := regexp.MatchString(filter, job.Name)
-			if err != nil {
-				panic(err)
-			}
-			if !matched {
-				continue
-			}
-		}
-		builds, err := store.GetBuilds(job.Name)
-		if err != nil {
-			fmt.Println("Could not load builds ", err)
-			return
-		}
-		for _, build := range builds {
-			fmt.Printf("%s,%d,%s,%d,%d,%s,%d,%d\n", build.Job, build.Number, build.Host,
-				build.Duration, build.Start, build.Result, build.Failed, build.Total)
+	if err := WriteTable(os.Stdout, format, hostTable(HostReport(builds, causeMap(causes), threshold))); err != nil {
+		fmt.Println("Could not write report ", err)
+	}
+}
+
+func TrendsReport(store BuildStore, filter, since string, window int, threshold float64, format string) {
+	builds, err := selectBuilds(store, filter, since)
+	if err != nil {
+		fmt.Println("Could not load builds ", err)
+		return
+	}
+	if err := WriteTable(os.Stdout, format, trendTable(Trends(builds, window, threshold))); err != nil {
+		fmt.Println("Could not write report ", err)
+	}
+}
+
+func QueryReport(store BuildStore, expr, format string) {
+	query, err := ParseQuery(expr, time.Now())
+	if err != nil {
+		fmt.Println("Invalid query ", err)
+		return
+	}
+	builds, err := query.Run(store)
+	if err != nil {
+		fmt.Println("Could not load builds ", err)
+		return
+	}
+	if err := WriteTable(os.Stdout, format, query.Table(builds)); err != nil {
+		fmt.Println("Could not write report ", err)
+	}
+}
+
+func formatStart(ms int64) string {
+	if ms <= 0 {
+		return "-"
+	}
+	return time.Unix(ms/1000, 0).Format("2006-01-02 15:04:05")
+}
+
+func PrintInfo(store BuildStore, path string) {
+	info, err := store.Info()
+	if err != nil {
+		fmt.Println("Could not read store ", err)
+		return
+	}
+	fmt.Println("Store", path)
+	fmt.Println("Schema version", info.Version)
+	fmt.Println("Jobs", info.Jobs)
+	fmt.Println("Builds", info.Builds)
+	fmt.Println("From", formatStart(info.First), "to", formatStart(info.Last))
+}
+
+// interruptible returns a context that is cancelled by SIGINT or SIGTERM,
+// after which a second signal kills the process. stop ends the handling
+// without cancelling anything.
+func interruptible() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan bool)
+	go func() {
+		select {
+		case <-signals:
+			signal.Stop(signals)
+			fmt.Println("Interrupted, committing what has been fetched")
+			cancel()
+		case <-done:
 		}
+	}()
+	return ctx, func() {
+		close(done)
+		signal.Stop(signals)
+		cancel()
 	}
 }
 
@@ -219,21 +419,143 @@ func main() {
 	list := flag.Bool("list", false, "List existing job (possibly filtered)")
 	save := flag.Bool("store", false, "Store new jobs")
 	refresh := flag.Bool("refresh", false, "Update job builds")
-	update := flag.Bool("update", false, "Update existing jobs")
+	update := flag.Bool("update", false, "Also update builds that were still running at the last refresh")
+	retryFailed := flag.Bool("retry-failed", false, "Only refresh the jobs that failed in an earlier refresh")
+	batch := flag.Int("batch", 100, "Commit refreshed builds every this many builds")
+	parallel := flag.Int("parallel", 4, "Number of jobs to refresh at the same time")
+	archiveMissing := flag.Bool("archive-missing", false, "Archive jobs missing from the server even when most of them are missing")
+	stages := flag.Bool("stages", false, "Also record the host and duration of each stage of pipeline builds when refreshing")
+	causes := flag.String("causes", "", "File of failure cause rules, 'category name regexp' per line (default built in rules)")
+	rate := flag.Float64("rate", 10, "Maximum requests per second to jenkins (0 for no limit)")
+	include := flag.String("include", "", "Track jobs matching this regular expression, new ones are picked up by -refresh")
+	exclude := flag.String("exclude", "", "Never track jobs matching this regular expression")
+	removeRule := flag.String("remove-rule", "", "Remove the include or exclude rule with this pattern")
+	rules := flag.Bool("rules", false, "List tracking rules")
+	snapshot := flag.String("snapshot", "", "Write the whole store, whatever its backend, to this file as a SQLite database (gzipped for .gz names)")
+	restore := flag.String("restore", "", "Load a SQLite snapshot (.bz2, .gz or plain) into the store, older snapshots are migrated")
 	builds := flag.Bool("builds", false, "Get builds for job")
-	export := flag.Bool("export", false, "Export to CSV (possibly filtered)")
+	export := flag.Bool("export", false, "Export builds (possibly filtered)")
+	exportFormat := flag.String("export-format", "csv", "Export format: csv, jsonl or sqlite")
+	out := flag.String("out", "", "Export to this file instead of stdout (a SQLite store for -export-format sqlite)")
+	result := flag.String("result", "", "Only export builds with this result, like FAILURE")
+	host := flag.String("host", "", "Only export builds on hosts matching this regular expression")
+	numbers := flag.String("numbers", "", "Only export builds in this number range, like 100-200, 100- or 150")
+	until := flag.String("until", "", "Only export builds started before this (a window like 7d or a date)")
+	iso := flag.Bool("iso", false, "Export ISO-8601 start times and human durations instead of milliseconds")
+	info := flag.Bool("info", false, "Show schema version, counts and date range of the store")
+	hosts := flag.Bool("hosts", false, "Report failure statistics per host (possibly filtered)")
+	since := flag.String("since", "", "Only use builds started within this long, like 720h or 30d, or since a date like 2013-05-01")
+	threshold := flag.Float64("threshold", 2, "Flag hosts whose failures are this many standard deviations above the job baselines")
+	trends := flag.Bool("trends", false, "Report build duration trends and regressions per job (possibly filtered)")
+	retain := flag.String("retain", "", "Add or replace a retention rule like '^release- days=90 builds=200 failures=365'")
+	removeRetention := flag.String("remove-retention", "", "Remove the retention rule with this pattern")
+	retention := flag.Bool("retention", false, "List retention rules")
+	prune := flag.Bool("prune", false, "Delete the builds that the retention rules do not keep")
+	dryRun := flag.Bool("dry-run", false, "Only show what -prune would delete")
+	rollup := flag.Bool("rollup", false, "Keep daily per job and host counts of pruned builds for reports")
+	serve := flag.String("serve", "", "Serve a JSON api and html pages over the store on this address, like :8080")
+	changes := flag.Bool("changes", false, "List the commits in the builds selected by -query, like 'result=FAILURE and host=node1'")
+	query := flag.String("query", "", "Report builds matching an expression like 'result=FAILURE and start>30d group by host'")
+	window := flag.Int("window", 10, "Number of builds in the rolling duration baseline")
+	slower := flag.Float64("slower", 0.3, "Report a regression when builds get this much slower (0.3 is 30%)")
+	format := flag.String("format", "table", "Report format: table, csv or json")
+	db := flag.String("db", DefaultStorePath(), "Store location, a SQLite file or a postgres:// url")
 	filter := flag.String("filter", "", "Jobs list filter (a regular expression)")
+	profile := flag.String("profile", "", "Jenkins profile from ~/.jenkins (default $JENKINS_PROFILE or the first)")
+	timeout := flag.Duration("timeout", 0, "Timeout for each request to jenkins (overrides the profile)")
 	flag.Parse()
+	if err := checkFormat(*format); err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	var j jenkins.Jenkins
+	if *list || *save || *refresh || *builds || *include != "" || *exclude != "" || *removeRule != "" {
+		var err error
+		j, err = jenkins.NewFromProfile(*profile)
+		if err != nil {
+			fmt.Println("Could not configure jenkins: " + err.Error())
+			return
+		}
+		if *timeout > 0 {
+			jenkins.SetTimeout(*timeout)
+		}
+		jenkins.SetRateLimit(*rate)
+	}
+	var store BuildStore
+	writes := *include != "" || *exclude != "" || *removeRule != "" || *retain != "" || *removeRetention != "" || (*prune && !*dryRun)
+	if *save || *refresh || *export || *info || *hosts || *trends || *changes || *query != "" || *serve != "" || writes || *rules || *retention || *prune || *snapshot != "" || *restore != "" {
+		var err error
+		store, err = OpenStore(*db)
+		if err != nil {
+			fmt.Println("Could not open store ", err)
+			return
+		}
+		defer store.Close()
+		if *save || *refresh || writes || *restore != "" {
+			if err = store.Lock(); err != nil {
+				fmt.Println("Could not lock store ", err)
+				return
+			}
+		}
+	}
 	if *list {
-		ListJobs(*filter)
+		ListJobs(j, *filter)
 	} else if *save {
-		SaveJobs(flag.Args())
+		SaveJobs(j, store, flag.Args())
 	} else if *refresh {
-		RefreshBuilds(*update)
+		causeRules, err := LoadCauseRules(*causes)
+		if err != nil {
+			fmt.Println("Could not load cause rules ", err)
+			return
+		}
+		ctx, stop := interruptible()
+		RefreshBuilds(ctx, j, store, RefreshOptions{*update, *retryFailed, *batch, *parallel, *archiveMissing, causeRules, *stages})
+		stop()
+	} else if *include != "" {
+		AddRule(j, store, *include, false)
+	} else if *exclude != "" {
+		AddRule(j, store, *exclude, true)
+	} else if *removeRule != "" {
+		RemoveRule(j, store, *removeRule)
+	} else if *rules {
+		ListRules(store)
+	} else if *retain != "" {
+		AddRetention(store, *retain)
+	} else if *removeRetention != "" {
+		RemoveRetention(store, *removeRetention)
+	} else if *retention {
+		ListRetention(store)
+	} else if *prune {
+		if err := Prune(store, *dryRun, *rollup); err != nil {
+			fmt.Println("Could not prune ", err)
+		}
+	} else if *snapshot != "" {
+		if err := Snapshot(store, *snapshot); err != nil {
+			fmt.Println("Could not write snapshot ", err)
+		}
+	} else if *restore != "" {
+		if err := Restore(store, *restore); err != nil {
+			fmt.Println("Could not restore snapshot ", err)
+		}
 	} else if *builds {
-		GetBuilds(flag.Args())
+		GetBuilds(j, flag.Args())
 	} else if *export {
-		ExportBuilds(*filter)
+		opts := ExportOptions{*exportFormat, *out, *filter, *host, *result, *since, *until, *numbers, *iso}
+		if err := ExportBuilds(os.Stdout, store, opts); err != nil {
+			fmt.Println("Could not export ", err)
+		}
+	} else if *info {
+		PrintInfo(store, *db)
+	} else if *hosts {
+		HostsReport(store, *filter, *since, *threshold, *format)
+	} else if *serve != "" {
+		Serve(store, *serve, *threshold, *window, *slower)
+	} else if *changes {
+		ChangesReport(store, *query, *format)
+	} else if *query != "" {
+		QueryReport(store, *query, *format)
+	} else if *trends {
+		TrendsReport(store, *filter, *since, *window, *slower, *format)
 	} else {
 		fmt.Println("Don't know what to do (run -help)")
 	}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/jwiklund/jenkins"
+	"io"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeJenkins serves jobs, builds and consoles from maps, anything else
+// panics on the nil embedded interface
+type fakeJenkins struct {
+	jenkins.Jenkins
+	jobs     []jenkins.Job
+	builds   map[string][]jenkins.BuildInfo
+	consoles map[string]string
+	reads    map[string]int
+	broken   map[string]bool
+	stages   map[string][]jenkins.Stage
+}
+
+func (f fakeJenkins) Server() string {
+	return "http://fake/jenkins"
+}
+
+func (f fakeJenkins) Jobs() ([]jenkins.Job, error) {
+	return f.jobs, nil
+}
+
+func (f fakeJenkins) JobBuilds(job string) ([]jenkins.BuildInfo, error) {
+	return f.builds[job], nil
+}
+
+func (f fakeJenkins) JobBuildsRange(job string, from, to int) ([]jenkins.BuildInfo, error) {
+	if f.broken[job] {
+		return nil, errors.New("broken " + job)
+	}
+	builds := f.builds[job]
+	if from > len(builds) {
+		from = len(builds)
+	}
+	if to > len(builds) {
+		to = len(builds)
+	}
+	return builds[from:to], nil
+}
+
+func (f fakeJenkins) BuildInfo(ref jenkins.BuildRef) (jenkins.BuildInfo, error) {
+	for _, build := range f.builds[ref.Job] {
+		if build.Number == ref.Number {
+			return build, nil
+		}
+	}
+	return jenkins.BuildInfo{}, errors.New("no build " + ref.String())
+}
+
+func (f fakeJenkins) Stages(ref jenkins.BuildRef) ([]jenkins.Stage, error) {
+	stages, ok := f.stages[ref.String()]
+	if !ok {
+		return nil, errors.New("no stages for " + ref.String())
+	}
+	return stages, nil
+}
+
+func (f fakeJenkins) Console(ref jenkins.BuildRef) (io.ReadCloser, error) {
+	console, ok := f.consoles[ref.String()]
+	if !ok {
+		return nil, errors.New("no console for " + ref.String())
+	}
+	f.reads[ref.String()]++
+	return io.NopCloser(strings.NewReader(console)), nil
+}
+
+func newFake() fakeJenkins {
+	return fakeJenkins{
+		jobs: []jenkins.Job{{Name: "job1", Url: "http://fake/jenkins/job/job1/"}, {Name: "job2", Url: "http://fake/jenkins/job/job2/"}},
+		builds: map[string][]jenkins.BuildInfo{
+			"job1": {
+				{Number: 2, Result: "FAILURE", Timestamp: 2000, Duration: 20, Actions: []jenkins.BuildAction{{FailCount: 1, TotalCount: 10}}},
+				{Number: 1, Result: "SUCCESS", Timestamp: 1000, Duration: 10},
+			},
+		},
+		reads:  make(map[string]int),
+		broken: make(map[string]bool),
+		consoles: map[string]string{
+			"job1#1": "Started\nNode Controller: host1\nFinished: SUCCESS\n",
+			"job1#2": "Started\nNode Controller: host2\nFinished: FAILURE\n",
+		},
+	}
+}
+
+func TestRefreshBuilds(t *testing.T) {
+	j := newFake()
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1", "missing"})
+	jobs, _ := store.GetJobs()
+	if len(jobs) != 1 || jobs[0].Server != "http://fake/jenkins" {
+		t.Fatalf("Expected job1 to be stored but got %v", jobs)
+	}
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{})
+	builds, _ := store.GetBuilds("http://fake/jenkins", "job1")
+	if len(builds) != 2 {
+		t.Fatalf("Expected 2 builds but got %v", builds)
+	}
+	if builds[1] != (Build{"http://fake/jenkins", "job1", 2, 2000, 20, "host2", "FAILURE", 1, 10}) {
+		t.Fatalf("Unexpected build %+v", builds[1])
+	}
+	if builds[0].Host != "host1" || builds[0].Failed != -1 {
+		t.Fatalf("Unexpected build %+v", builds[0])
+	}
+}
+
+func TestRefreshBuildsUpdate(t *testing.T) {
+	j := newFake()
+	j.builds["job1"][0].Result = ""
+	j.builds["job1"][0].Building = true
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1"})
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{})
+	j.builds["job1"][0].Result = "ABORTED"
+	j.builds["job1"][0].Building = false
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{})
+	builds, _ := store.GetBuilds("http://fake/jenkins", "job1")
+	if builds[1].Result != "" {
+		t.Fatalf("Expected running build to be kept without -update but got %+v", builds[1])
+	}
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{Update: true})
+	builds, _ = store.GetBuilds("http://fake/jenkins", "job1")
+	if builds[1].Result != "ABORTED" || builds[1].Host != "host2" {
+		t.Fatalf("Expected build to be updated but got %+v", builds[1])
+	}
+	if j.reads["job1#2"] != 1 || j.reads["job1#1"] != 1 {
+		t.Fatalf("Expected each console to be read once but got %v", j.reads)
+	}
+}
+
+func TestRefreshBuildsIncremental(t *testing.T) {
+	j := newFake()
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1"})
+	for i := 3; i < 3+2*pageSize; i++ {
+		j.consoles["job1#"+strconv.Itoa(i)] = "Node Controller: host3\n"
+	}
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{})
+	for i := 3; i < 3+2*pageSize; i++ {
+		j.builds["job1"] = append([]jenkins.BuildInfo{{Number: i, Result: "SUCCESS", Timestamp: int64(i) * 1000, Duration: 10}}, j.builds["job1"]...)
+	}
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{})
+	last, _ := store.LastBuild("http://fake/jenkins", "job1")
+	if last != 2+2*pageSize {
+		t.Fatalf("Expected last build %d but got %d", 2+2*pageSize, last)
+	}
+	for console, reads := range j.reads {
+		if reads != 1 {
+			t.Fatalf("Expected %s to be read once but got %d", console, reads)
+		}
+	}
+}
+
+func TestRefreshBuildsBatch(t *testing.T) {
+	j := newFake()
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1"})
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{Batch: 1})
+	if store.commits != 3 {
+		t.Fatalf("Expected a commit per build and a final one but got %d", store.commits)
+	}
+}
+
+func TestRefreshBuildsRetryFailed(t *testing.T) {
+	j := newFake()
+	j.jobs = append(j.jobs, jenkins.Job{Name: "job3", Url: "http://fake/jenkins/job/job3/"})
+	j.builds["job3"] = []jenkins.BuildInfo{{Number: 1, Result: "SUCCESS", Timestamp: 1000, Duration: 10}}
+	j.consoles["job3#1"] = "Node Controller: host3\n"
+	j.broken["job1"] = true
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1", "job3"})
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{})
+	failed, _ := store.FailedJobs()
+	if len(failed) != 1 || failed[0].Name != "job1" {
+		t.Fatalf("Expected job1 to be logged as failed but got %v", failed)
+	}
+	j.broken["job1"] = false
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{RetryFailed: true})
+	failed, _ = store.FailedJobs()
+	if len(failed) != 0 {
+		t.Fatalf("Expected no failures after retry but got %v", failed)
+	}
+	builds, _ := store.GetBuilds("http://fake/jenkins", "job1")
+	if len(builds) != 2 {
+		t.Fatalf("Expected job1 builds after retry but got %v", builds)
+	}
+	if j.reads["job3#1"] != 1 {
+		t.Fatalf("Expected only the failed job to be retried but job3 was read %d times", j.reads["job3#1"])
+	}
+}
+
+func TestRefreshBuildsInterrupted(t *testing.T) {
+	j := newFake()
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	RefreshBuilds(ctx, j, store, RefreshOptions{})
+	if store.inTx {
+		t.Fatalf("Expected the refresh to be committed")
+	}
+	failed, _ := store.FailedJobs()
+	if len(failed) != 1 {
+		t.Fatalf("Expected the interrupted job to be logged but got %v", failed)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	p := newProgress(4)
+	p.build()
+	p.build()
+	p.done = 1
+	status := p.status(p.start.Add(30 * time.Second))
+	if status != "Progress: 1/4 jobs, 2 builds, ETA 1m30s" {
+		t.Fatalf("Unexpected status %q", status)
+	}
+	p.done = 4
+	if status = p.status(p.start.Add(time.Minute)); status != "Progress: 4/4 jobs, 2 builds" {
+		t.Fatalf("Unexpected status %q", status)
+	}
+}
+
+func TestInterruptible(t *testing.T) {
+	ctx, stop := interruptible()
+	if ctx.Err() != nil {
+		t.Fatal("Did not expect a new context to be cancelled")
+	}
+	stop()
+	if ctx.Err() == nil {
+		t.Fatal("Expected stop to cancel the context")
+	}
+	ctx, stop = interruptible()
+	defer stop()
+	syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected SIGINT to cancel the context")
+	}
+}
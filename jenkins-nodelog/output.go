@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+// Table is what reports produce, it can be written in any of the formats
+type Table struct {
+	Headers []string
+	Rows    [][]string
+}
+
+func (t *Table) Add(values ...interface{}) {
+	row := make([]string, len(values))
+	for i, v := range values {
+		row[i] = fmt.Sprint(v)
+	}
+	t.Rows = append(t.Rows, row)
+}
+
+var formats = []string{"table", "csv", "json"}
+
+func checkFormat(format string) error {
+	for _, f := range formats {
+		if f == format {
+			return nil
+		}
+	}
+	return errors.New("Unknown format " + format + ", use one of " + strings.Join(formats, ", "))
+}
+
+// WriteTable writes an aligned table, RFC 4180 csv or json lines with one
+// object per row keyed by the headers
+func WriteTable(w io.Writer, format string, t Table) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(t.Headers, "\t"))
+		for _, row := range t.Rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write(t.Headers)
+		cw.WriteAll(t.Rows)
+		return cw.Error()
+	case "json":
+		encoder := json.NewEncoder(w)
+		for _, row := range t.Rows {
+			obj := make(map[string]string)
+			for i, header := range t.Headers {
+				if i < len(row) {
+					obj[header] = row[i]
+				}
+			}
+			if err := encoder.Encode(obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return checkFormat(format)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	_ "github.com/lib/pq"
+)
+
+// postgresMigrations start out at the current SQLite schema, the version
+// numbers are shared so -info means the same thing for both. A migration
+// added to one list has to be added to the other, TestPostgresMigrations
+// compares the columns they end up with.
+var postgresMigrations = []migration{
+	{4, "create typed tables and indexes", []string{
+		"create table jobs(server text not null, name text not null, url text not null default '', primary key(server, name))",
+		"create table builds(server text not null, job text not null, number integer not null, start bigint, duration bigint, " +
+			"host text, result text, failed integer, total integer, primary key(server, job, number))",
+		"create index builds_job on builds(job)",
+		"create index builds_host on builds(host)",
+		"create index builds_start on builds(start)",
+	}},
+	{5, "add refresh log", []string{
+		"create table refresh_log(server text not null, job text not null, time bigint not null, error text not null, primary key(server, job))",
+	}},
+	{6, "add tracking rules and archived jobs", []string{
+		"create table rules(server text not null, pattern text not null, exclude integer not null, primary key(server, pattern))",
+		"alter table jobs add column archived bigint not null default 0",
+	}},
+	{7, "add retention rules and rollups", []string{
+		"create table retention(pattern text primary key, position integer not null, days integer not null, builds integer not null, failure_days integer not null)",
+		"create table rollups(server text not null, job text not null, host text not null, day text not null, result text not null, " +
+			"builds integer not null, duration bigint not null, primary key(server, job, host, day, result))",
+	}},
+	{8, "add failure causes", []string{
+		"create table failure_causes(server text not null, job text not null, number integer not null, " +
+			"category text not null, cause text not null, excerpt text not null, primary key(server, job, number))",
+	}},
+	{9, "add build metadata", []string{
+		"create table build_meta(server text not null, job text not null, number integer not null, " +
+			"built_on text not null, upstream_job text not null, upstream_number integer not null, primary key(server, job, number))",
+		"create table build_causes(server text not null, job text not null, number integer not null, position integer not null, " +
+			"kind text not null, description text not null, user_id text not null, primary key(server, job, number, position))",
+		"create table build_parameters(server text not null, job text not null, number integer not null, " +
+			"name text not null, value text not null, primary key(server, job, number, name))",
+		"create table build_changes(server text not null, job text not null, number integer not null, position integer not null, " +
+			"commit_id text not null, author text not null, message text not null, primary key(server, job, number, position))",
+		"create table build_culprits(server text not null, job text not null, number integer not null, position integer not null, " +
+			"name text not null, primary key(server, job, number, position))",
+		"create index build_changes_author on build_changes(author)",
+	}},
+	{10, "add pipeline stages", []string{
+		"create table build_stages(server text not null, job text not null, number integer not null, position integer not null, " +
+			"name text not null, status text not null, host text not null, start bigint not null, duration bigint not null, primary key(server, job, number, position))",
+		"create index build_stages_host on build_stages(host)",
+	}},
+}
+
+// lockKey identifies the nodelog writer lock among advisory locks
+const lockKey = 0x6e6f64656c6f67
+
+func OpenPostgres(url string) (*SQLStore, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	store := &SQLStore{db: db, driver: "postgres", path: url}
+	if err = store.migrate(); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// lockPostgres holds a session advisory lock on a dedicated connection,
+// it is released when Close closes that connection
+func (s *SQLStore) lockPostgres() error {
+	if s.conn != nil {
+		return nil
+	}
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return err
+	}
+	var locked bool
+	if err := conn.QueryRowContext(context.Background(), "select pg_try_advisory_lock($1)", lockKey).Scan(&locked); err != nil {
+		conn.Close()
+		return err
+	}
+	if !locked {
+		conn.Close()
+		return errors.New("Store is locked by another process")
+	}
+	s.conn = conn
+	return nil
+}
+
+// lockPostgresMigrations waits for the writer lock on a dedicated
+// connection, it has to be unlocked since closing only returns the
+// connection to the pool
+func (s SQLStore) lockPostgresMigrations() (func(), error) {
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(context.Background(), "select pg_advisory_lock($1)", lockKey); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(context.Background(), "select pg_advisory_unlock($1)", lockKey)
+		conn.Close()
+	}, nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jwiklund/jenkins"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRebind(t *testing.T) {
+	for query, expected := range map[string]string{
+		"select * from jobs":                                         "select * from jobs",
+		"select * from jobs where server = ?":                        "select * from jobs where server = $1",
+		"insert into builds values (?, ?, ?)":                        "insert into builds values ($1, $2, $3)",
+		"update jobs set archived = ? where server = ? and name = ?": "update jobs set archived = $1 where server = $2 and name = $3",
+	} {
+		if actual := (SQLStore{driver: "postgres"}).rebind(query); actual != expected {
+			t.Fatalf("Expected %s but got %s", expected, actual)
+		}
+		if actual := (SQLStore{driver: "sqlite3"}).rebind(query); actual != query {
+			t.Fatalf("Expected SQLite queries unchanged but got %s", actual)
+		}
+	}
+}
+
+var (
+	createdName = regexp.MustCompile(`^create (table|index) (\w+)`)
+	addedColumn = regexp.MustCompile(`^alter table (\w+) add column (\w+) (\w+)`)
+)
+
+// columnType compares postgres and SQLite types, SQLite integers are 64
+// bits so they are bigint in postgres
+func columnType(kind string) string {
+	kind = strings.ToLower(kind)
+	if kind == "bigint" {
+		return "integer"
+	}
+	return kind
+}
+
+// schemaOf lists the indexes and the columns of each table with their
+// types as created by the statements
+func schemaOf(list []migration) []string {
+	var schema []string
+	for _, m := range list {
+		for _, stmt := range m.stmts {
+			if match := addedColumn.FindStringSubmatch(stmt); match != nil {
+				schema = append(schema, "column "+match[1]+"."+match[2]+" "+columnType(match[3]))
+				continue
+			}
+			match := createdName.FindStringSubmatch(stmt)
+			if match == nil {
+				continue
+			}
+			if match[1] == "index" {
+				schema = append(schema, "index "+match[2])
+				continue
+			}
+			depth := 0
+			column := ""
+			body := stmt[strings.Index(stmt, "(")+1 : strings.LastIndex(stmt, ")")]
+			for _, c := range body + "," {
+				switch {
+				case c == '(':
+					depth++
+				case c == ')':
+					depth--
+				case c == ',' && depth == 0:
+					if fields := strings.Fields(column); fields[0] != "primary" {
+						schema = append(schema, "column "+match[2]+"."+fields[0]+" "+columnType(fields[1]))
+					}
+					column = ""
+					continue
+				}
+				column += string(c)
+			}
+		}
+	}
+	sort.Strings(schema)
+	return schema
+}
+
+// sqliteSchema lists the indexes and typed columns of a migrated store
+func sqliteSchema(t *testing.T, store *SQLStore) []string {
+	rows, err := store.db.Query("select type, name from sqlite_master where type in ('table', 'index') and name not like 'sqlite_%' and name != 'migrations'")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	var tables, schema []string
+	for rows.Next() {
+		var kind, name string
+		if err := rows.Scan(&kind, &name); err != nil {
+			t.Fatal(err.Error())
+		}
+		if kind == "table" {
+			tables = append(tables, name)
+		} else {
+			schema = append(schema, "index "+name)
+		}
+	}
+	rows.Close()
+	for _, table := range tables {
+		rows, err := store.db.Query("select name, type from pragma_table_info(?)", table)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for rows.Next() {
+			var name, kind string
+			if err := rows.Scan(&name, &kind); err != nil {
+				t.Fatal(err.Error())
+			}
+			schema = append(schema, "column "+table+"."+name+" "+columnType(kind))
+		}
+		rows.Close()
+	}
+	sort.Strings(schema)
+	return schema
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	first := postgresMigrations[0].version
+	var expected []migration
+	for _, m := range migrations {
+		if m.version >= first {
+			expected = append(expected, m)
+		}
+	}
+	if len(expected) != len(postgresMigrations) {
+		t.Fatalf("Expected %d postgres migrations from version %d but got %d", len(expected), first, len(postgresMigrations))
+	}
+	for i, m := range postgresMigrations {
+		if m.version != expected[i].version {
+			t.Fatalf("Expected postgres migration %d to be version %d but got %d", i, expected[i].version, m.version)
+		}
+		// the first one creates the whole schema so it is named differently
+		if i > 0 && m.name != expected[i].name {
+			t.Fatalf("Expected version %d to be %q but got %q", m.version, expected[i].name, m.name)
+		}
+	}
+
+	// both a new store and one upgraded from before the typed columns have
+	// to end up with the same columns as postgres
+	for _, script := range []string{"", "migrate_v3_test.sql"} {
+		store := openFixture(t, fixtureStore(t, script))
+		sqlite := sqliteSchema(t, store)
+		store.Close()
+		if postgres := schemaOf(postgresMigrations); !reflect.DeepEqual(postgres, sqlite) {
+			t.Fatalf("Expected the postgres schema to have\n%s\nbut got\n%s", strings.Join(sqlite, "\n"), strings.Join(postgres, "\n"))
+		}
+	}
+}
+
+// TestPostgresStore runs against the database in NODELOG_TEST_POSTGRES, a
+// postgres:// url, in a schema of its own that is dropped afterwards
+func TestPostgresStore(t *testing.T) {
+	url := os.Getenv("NODELOG_TEST_POSTGRES")
+	if url == "" {
+		t.Skip("NODELOG_TEST_POSTGRES not set")
+	}
+	admin, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer admin.Close()
+	schema := "nodelog_test_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if _, err := admin.Exec("create schema " + schema); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer admin.Exec("drop schema " + schema + " cascade")
+	if strings.Contains(url, "?") {
+		url += "&search_path=" + schema
+	} else {
+		url += "?search_path=" + schema
+	}
+
+	j := newFake()
+	j.consoles["job1#2"] = "Started\nNode Controller: host2\nThere are test failures.\nFinished: FAILURE\n"
+	j.builds["job1"][0].Actions = append(j.builds["job1"][0].Actions, jenkins.BuildAction{
+		Causes:     []jenkins.BuildCause{{Class: "hudson.model.Cause$UserIdCause", ShortDescription: "Started by user ada", UserId: "ada"}},
+		Parameters: []jenkins.BuildParameter{{Name: "BRANCH", Value: "main"}},
+	})
+	j.stages = map[string][]jenkins.Stage{"job1#2": {{Id: "6", Name: "Build", Status: "SUCCESS", Node: "agent3", Start: 2000, Duration: 5}}}
+	memory := NewMemoryStore()
+	SaveJobs(j, memory, []string{"job1", "job2"})
+	AddRule(j, memory, "^job", false)
+	RefreshBuilds(context.Background(), j, memory, RefreshOptions{Causes: DefaultCauseRules(), Stages: true})
+
+	store, err := OpenPostgres(url)
+	if err != nil {
+		t.Fatalf("Could not open store: %s", err.Error())
+	}
+	defer store.Close()
+	if err := store.Lock(); err != nil {
+		t.Fatal(err.Error())
+	}
+	other, err := OpenPostgres(url)
+	if err != nil {
+		t.Fatalf("Could not open an already migrated store: %s", err.Error())
+	}
+	if err := other.Lock(); err == nil {
+		t.Fatal("Expected a second writer to be refused")
+	}
+	other.Close()
+	if err := store.Begin(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := CopyStore(memory, store); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := store.Commit(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	info, err := store.Info()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if info.Version != postgresMigrations[len(postgresMigrations)-1].version || info.Jobs != 2 || info.Builds != 2 {
+		t.Fatalf("Expected the latest version with 2 jobs and 2 builds but got %+v", info)
+	}
+	for _, name := range []string{"job1", "job2"} {
+		expected, _ := memory.GetBuilds("http://fake/jenkins", name)
+		builds, err := store.GetBuilds("http://fake/jenkins", name)
+		if err != nil || !reflect.DeepEqual(builds, expected) {
+			t.Fatalf("Expected %+v but got %+v %v", expected, builds, err)
+		}
+		expectedMetas, _ := memory.GetMetas("http://fake/jenkins", name)
+		metas, err := store.GetMetas("http://fake/jenkins", name)
+		if err != nil || !reflect.DeepEqual(metas, expectedMetas) {
+			t.Fatalf("Expected %+v but got %+v %v", expectedMetas, metas, err)
+		}
+	}
+	causes, err := store.GetCauses()
+	if err != nil || len(causes) != 1 || causes[0].Category != "test" {
+		t.Fatalf("Expected the test failure cause but got %+v %v", causes, err)
+	}
+	rules, err := store.GetRules()
+	if err != nil || len(rules) != 1 || rules[0] != (Rule{"http://fake/jenkins", "^job", false}) {
+		t.Fatalf("Expected the include rule but got %+v %v", rules, err)
+	}
+	failed, err := store.QueryBuilds(BuildQuery{Result: "FAILURE"})
+	if err != nil || len(failed) != 1 || failed[0].Number != 2 {
+		t.Fatalf("Expected the failed build but got %+v %v", failed, err)
+	}
+}
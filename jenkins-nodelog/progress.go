@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// progress counts finished jobs and fetched builds during a refresh
+type progress struct {
+	mu     sync.Mutex
+	start  time.Time
+	total  int
+	done   int
+	builds int
+}
+
+func newProgress(total int) *progress {
+	return &progress{start: time.Now(), total: total}
+}
+
+func (p *progress) build() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.builds++
+}
+
+// job marks a job as done and returns the progress line to show
+func (p *progress) job() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	return p.status(time.Now())
+}
+
+func (p *progress) status(now time.Time) string {
+	line := "Progress: " + strconv.Itoa(p.done) + "/" + strconv.Itoa(p.total) + " jobs, " +
+		strconv.Itoa(p.builds) + " builds"
+	if p.done > 0 && p.done < p.total {
+		elapsed := now.Sub(p.start)
+		eta := elapsed / time.Duration(p.done) * time.Duration(p.total-p.done)
+		line += ", ETA " + eta.Truncate(time.Second).String()
+	}
+	return line
+}
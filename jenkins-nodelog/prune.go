@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retention decides which builds of the jobs matching Pattern to keep: the
+// newest Builds builds, those started within Days and failures started
+// within FailureDays. Zero means no limit of that kind, the first rule
+// matching a job is used and jobs without a rule keep everything. The
+// newest build of a job is always kept, refresh continues after it.
+type Retention struct {
+	Pattern     string
+	Days        int
+	Builds      int
+	FailureDays int
+}
+
+func (r Retention) String() string {
+	return r.Pattern + " days=" + strconv.Itoa(r.Days) + " builds=" + strconv.Itoa(r.Builds) +
+		" failures=" + strconv.Itoa(r.FailureDays)
+}
+
+// ParseRetention reads a rule like "^release- days=90 builds=200 failures=365"
+func ParseRetention(spec string) (Retention, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return Retention{}, errors.New("Empty retention rule")
+	}
+	rule := Retention{Pattern: fields[0]}
+	if _, err := regexp.Compile(rule.Pattern); err != nil {
+		return Retention{}, errors.New("Invalid pattern " + rule.Pattern + ": " + err.Error())
+	}
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return Retention{}, errors.New("Invalid retention option " + field)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(parts[1], "d"))
+		if err != nil || n < 0 {
+			return Retention{}, errors.New("Invalid retention option " + field)
+		}
+		switch parts[0] {
+		case "days":
+			rule.Days = n
+		case "builds":
+			rule.Builds = n
+		case "failures":
+			rule.FailureDays = n
+		default:
+			return Retention{}, errors.New("Unknown retention option " + parts[0] + ", use days, builds or failures")
+		}
+	}
+	if rule.Days == 0 && rule.Builds == 0 {
+		return Retention{}, errors.New("Retention rule " + rule.Pattern + " needs days or builds")
+	}
+	return rule, nil
+}
+
+// Expired returns the builds that no retention rule keeps, builds that are
+// still running and the newest build of each job are always kept
+func Expired(builds []Build, rules []Retention, now time.Time) []Build {
+	patterns := make([]*regexp.Regexp, len(rules))
+	for i, rule := range rules {
+		patterns[i] = regexp.MustCompile(rule.Pattern)
+	}
+	jobs := make(map[string][]Build)
+	var keys []string
+	for _, b := range builds {
+		key := b.Server + " " + b.Job
+		if _, ok := jobs[key]; !ok {
+			keys = append(keys, key)
+		}
+		jobs[key] = append(jobs[key], b)
+	}
+	sort.Strings(keys)
+	ms := func(days int) int64 {
+		return now.Add(-time.Duration(days)*24*time.Hour).UnixNano() / int64(time.Millisecond)
+	}
+	var expired []Build
+	for _, key := range keys {
+		jobBuilds := jobs[key]
+		var rule *Retention
+		for i := range rules {
+			if patterns[i].MatchString(jobBuilds[0].Job) {
+				rule = &rules[i]
+				break
+			}
+		}
+		if rule == nil {
+			continue
+		}
+		sort.Slice(jobBuilds, func(a, b int) bool { return jobBuilds[a].Number > jobBuilds[b].Number })
+		for i, b := range jobBuilds {
+			keep := i == 0 || b.Result == "" ||
+				(rule.Builds > 0 && i < rule.Builds) ||
+				(rule.Days > 0 && b.Start >= ms(rule.Days)) ||
+				(rule.FailureDays > 0 && b.Result == "FAILURE" && b.Start >= ms(rule.FailureDays))
+			if !keep {
+				expired = append(expired, b)
+			}
+		}
+	}
+	return expired
+}
+
+// Rollup is the number and total duration of the builds of a job on a
+// host that ended with a result on a day, kept after the builds are pruned
+type Rollup struct {
+	Server   string
+	Job      string
+	Host     string
+	Day      string
+	Result   string
+	Builds   int
+	Duration int64
+}
+
+func rollupDay(ms int64) string {
+	return time.Unix(ms/1000, 0).Format("2006-01-02")
+}
+
+func rollupsOf(builds []Build) []Rollup {
+	index := make(map[Rollup]int)
+	var rollups []Rollup
+	for _, b := range builds {
+		key := Rollup{Server: b.Server, Job: b.Job, Host: b.Host, Day: rollupDay(b.Start), Result: b.Result}
+		i, ok := index[key]
+		if !ok {
+			i = len(rollups)
+			index[key] = i
+			rollups = append(rollups, key)
+		}
+		rollups[i].Builds++
+		if b.Duration > 0 {
+			rollups[i].Duration += b.Duration
+		}
+	}
+	return rollups
+}
+
+// rolledUpBuilds turns rollups back into builds with the average duration
+// so reports can use them. They get negative numbers ordered by day so
+// they sort before the builds that are still stored.
+func rolledUpBuilds(rollups []Rollup, pattern *regexp.Regexp, from int64) []Build {
+	sort.SliceStable(rollups, func(a, b int) bool { return rollups[a].Day < rollups[b].Day })
+	count := make(map[string]int)
+	for _, r := range rollups {
+		count[r.Server+" "+r.Job] += r.Builds
+	}
+	var builds []Build
+	for _, r := range rollups {
+		key := r.Server + " " + r.Job
+		start, err := time.ParseInLocation("2006-01-02", r.Day, time.Local)
+		if err != nil {
+			continue
+		}
+		ms := start.UnixNano() / int64(time.Millisecond)
+		for i := 0; i < r.Builds; i++ {
+			number := -count[key]
+			count[key]--
+			if (pattern != nil && !pattern.MatchString(r.Job)) || ms < from {
+				continue
+			}
+			builds = append(builds, Build{r.Server, r.Job, number, ms, r.Duration / int64(r.Builds), r.Host, r.Result, -1, -1})
+		}
+	}
+	return builds
+}
+
+func AddRetention(store BuildStore, spec string) {
+	rule, err := ParseRetention(spec)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := store.PutRetention(rule); err != nil {
+		fmt.Println("Could not add retention rule ", err)
+		return
+	}
+	fmt.Println("Retention " + rule.String())
+}
+
+func RemoveRetention(store BuildStore, pattern string) {
+	if err := store.DeleteRetention(pattern); err != nil {
+		fmt.Println("Could not remove retention rule ", err)
+		return
+	}
+	fmt.Println("Removed retention rule " + pattern)
+}
+
+func ListRetention(store BuildStore) {
+	rules, err := store.GetRetention()
+	if err != nil {
+		fmt.Println("Could not load retention rules ", err)
+		return
+	}
+	for _, rule := range rules {
+		fmt.Println(rule.String())
+	}
+}
+
+// Prune deletes the builds no retention rule keeps, with rollup they are
+// first added to the daily rollups
+func Prune(store BuildStore, dryRun, rollup bool) error {
+	rules, err := store.GetRetention()
+	if err != nil {
+		return err
+	}
+	builds, err := store.QueryBuilds(BuildQuery{})
+	if err != nil {
+		return err
+	}
+	expired := Expired(builds, rules, time.Now())
+	perJob := make(map[string]int)
+	for _, b := range expired {
+		perJob[b.Server+" "+b.Job]++
+	}
+	var jobs []string
+	for job := range perJob {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	verb := "Pruned"
+	if dryRun {
+		verb = "Would prune"
+	}
+	for _, job := range jobs {
+		fmt.Println(verb, perJob[job], "builds of", job)
+	}
+	fmt.Println(verb, len(expired), "of", len(builds), "builds")
+	if dryRun || len(expired) == 0 {
+		return nil
+	}
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	if rollup {
+		for _, r := range rollupsOf(expired) {
+			if err := store.AddRollup(r); err != nil {
+				return errors.New("Could not roll up: " + err.Error())
+			}
+		}
+	}
+	for _, b := range expired {
+		if err := store.DeleteBuild(b); err != nil {
+			return errors.New("Could not delete " + b.String() + ": " + err.Error())
+		}
+	}
+	if err := store.Commit(); err != nil {
+		return err
+	}
+	return store.Vacuum()
+}
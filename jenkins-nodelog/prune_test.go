@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var pruneNow = time.Date(2026, 10, 1, 12, 0, 0, 0, time.Local)
+
+func daysAgo(days int) int64 {
+	return pruneNow.Add(-time.Duration(days)*24*time.Hour).UnixNano() / int64(time.Millisecond)
+}
+
+func pruneBuilds() []Build {
+	return []Build{
+		{"http://fake/jenkins", "app", 1, daysAgo(100), 1000, "host1", "FAILURE", -1, -1},
+		{"http://fake/jenkins", "app", 2, daysAgo(50), 2000, "host1", "SUCCESS", -1, -1},
+		{"http://fake/jenkins", "app", 3, daysAgo(40), 3000, "host2", "FAILURE", -1, -1},
+		{"http://fake/jenkins", "app", 4, daysAgo(40), 5000, "host2", "FAILURE", -1, -1},
+		{"http://fake/jenkins", "app", 5, daysAgo(5), 4000, "host1", "SUCCESS", -1, -1},
+		{"http://fake/jenkins", "app", 6, daysAgo(1), 4000, "host1", "", -1, -1},
+		{"http://fake/jenkins", "lib", 1, daysAgo(400), 1000, "host1", "SUCCESS", -1, -1},
+	}
+}
+
+func TestParseRetention(t *testing.T) {
+	rule, err := ParseRetention("^app days=30 builds=2 failures=60d")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if rule != (Retention{"^app", 30, 2, 60}) {
+		t.Fatalf("Unexpected rule %+v", rule)
+	}
+	for _, spec := range []string{"", "^app", "^app failures=10", "^app days=x", "^app weeks=1", "( days=1"} {
+		if _, err := ParseRetention(spec); err == nil {
+			t.Fatalf("Expected %q to fail", spec)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	rules := []Retention{{"^app", 30, 2, 60}}
+	expired := Expired(pruneBuilds(), rules, pruneNow)
+	var numbers []int
+	for _, b := range expired {
+		numbers = append(numbers, b.Number)
+	}
+	// 6 is running, 5 is recent and among the newest two, 4 and 3 are
+	// failures within 60 days and lib has no rule
+	if len(numbers) != 2 || numbers[0] != 2 || numbers[1] != 1 {
+		t.Fatalf("Expected builds 2 and 1 to expire but got %v", numbers)
+	}
+	// the newest build is kept even when every build is too old
+	if expired := Expired(pruneBuilds(), []Retention{{"^lib", 30, 0, 0}}, pruneNow); len(expired) != 0 {
+		t.Fatalf("Expected the only lib build to be kept but got %v", expired)
+	}
+}
+
+func TestPruneRefresh(t *testing.T) {
+	j := newFake()
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1"})
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{})
+	store.PutRetention(Retention{"^job", 30, 0, 0})
+	if err := Prune(store, false, true); err != nil {
+		t.Fatal(err.Error())
+	}
+	builds, _ := store.GetBuilds("http://fake/jenkins", "job1")
+	if len(builds) != 1 || builds[0].Number != 2 {
+		t.Fatalf("Expected only the newest build to be kept but got %v", builds)
+	}
+	delete(j.reads, "job1#1")
+	delete(j.reads, "job1#2")
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{})
+	if len(j.reads) != 0 {
+		t.Fatalf("Expected no console to be read again but got %v", j.reads)
+	}
+	builds, _ = store.GetBuilds("http://fake/jenkins", "job1")
+	if len(builds) != 1 {
+		t.Fatalf("Expected the pruned build to stay pruned but got %v", builds)
+	}
+}
+
+func TestPruneRollup(t *testing.T) {
+	store, err := OpenSQLite(filepath.Join(t.TempDir(), "nodelog.db"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	store.PutJob(Job{"http://fake/jenkins", "app", "", 0})
+	for _, b := range pruneBuilds() {
+		b.Start = time.Now().UnixNano()/int64(time.Millisecond) - (pruneNow.UnixNano()/int64(time.Millisecond) - b.Start)
+		store.InsertBuild(b)
+	}
+	store.PutRetention(Retention{"^app", 1, 1, 0})
+	store.PutRetention(Retention{"^app", 30, 1, 0})
+	if rules, _ := store.GetRetention(); len(rules) != 1 || rules[0].Days != 30 {
+		t.Fatalf("Expected the rule to be replaced but got %v", rules)
+	}
+	if err := Prune(store, true, true); err != nil {
+		t.Fatal(err.Error())
+	}
+	if info, _ := store.Info(); info.Builds != 7 {
+		t.Fatalf("Expected -dry-run to keep everything but got %+v", info)
+	}
+	if err := Prune(store, false, true); err != nil {
+		t.Fatal(err.Error())
+	}
+	if info, _ := store.Info(); info.Builds != 3 {
+		t.Fatalf("Expected 4 builds to be pruned but got %+v", info)
+	}
+	rollups, err := store.GetRollups()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(rollups) != 3 || rollups[2].Builds != 2 || rollups[2].Duration != 8000 {
+		t.Fatalf("Unexpected rollups %+v", rollups)
+	}
+	builds, err := selectBuilds(store, "^app$", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(builds) != 6 || builds[0].Number != -4 || builds[3].Number != -1 || builds[2].Duration != 4000 {
+		t.Fatalf("Expected rolled up builds before the stored ones but got %v", builds)
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The -query language filters builds with comparisons joined by and, or,
+// not and parentheses, optionally followed by group by:
+//
+//	result=FAILURE and host~"linux-2.6" and start>2026-09-01 group by host
+//
+// Operators are = != < <= > >= and ~ !~ for regular expressions. Start
+// takes dates or windows like 7d and duration takes 10m or milliseconds.
+// Simple conditions joined by and are handed to the store as a BuildQuery,
+// the whole expression is then checked on every build it returns.
+
+var queryFields = map[string]bool{
+	"server": false, "job": false, "host": false, "result": false,
+	"number": true, "start": true, "duration": true, "failed": true, "total": true,
+}
+
+type predicate interface {
+	match(b Build) bool
+}
+
+type and struct{ left, right predicate }
+type or struct{ left, right predicate }
+type not struct{ inner predicate }
+
+func (p and) match(b Build) bool { return p.left.match(b) && p.right.match(b) }
+func (p or) match(b Build) bool  { return p.left.match(b) || p.right.match(b) }
+func (p not) match(b Build) bool { return !p.inner.match(b) }
+
+type comparison struct {
+	field string
+	op    string
+	text  string
+	num   int64
+	re    *regexp.Regexp
+}
+
+func (c comparison) numeric(b Build) int64 {
+	switch c.field {
+	case "number":
+		return int64(b.Number)
+	case "start":
+		return b.Start
+	case "duration":
+		return b.Duration
+	case "failed":
+		return int64(b.Failed)
+	}
+	return int64(b.Total)
+}
+
+func (c comparison) match(b Build) bool {
+	if queryFields[c.field] {
+		v := c.numeric(b)
+		switch c.op {
+		case "=":
+			return v == c.num
+		case "!=":
+			return v != c.num
+		case "<":
+			return v < c.num
+		case "<=":
+			return v <= c.num
+		case ">":
+			return v > c.num
+		}
+		return v >= c.num
+	}
+	v := fieldValue(b, c.field)
+	switch c.op {
+	case "=":
+		return v == c.text
+	case "!=":
+		return v != c.text
+	case "~":
+		return c.re.MatchString(v)
+	}
+	return !c.re.MatchString(v)
+}
+
+func fieldValue(b Build, field string) string {
+	switch field {
+	case "server":
+		return b.Server
+	case "job":
+		return b.Job
+	case "host":
+		return b.Host
+	case "result":
+		return b.Result
+	case "number":
+		return strconv.Itoa(b.Number)
+	case "start":
+		return formatStart(b.Start)
+	case "duration":
+		return msDuration(b.Duration)
+	case "failed":
+		return strconv.Itoa(b.Failed)
+	}
+	return strconv.Itoa(b.Total)
+}
+
+// Query is a compiled -query expression
+type Query struct {
+	Store   BuildQuery
+	Filter  predicate
+	GroupBy []string
+}
+
+type token struct {
+	text   string
+	quoted bool
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"':
+			end := strings.IndexByte(expr[i+1:], '"')
+			if end == -1 {
+				return nil, errors.New("Unterminated string in query")
+			}
+			tokens = append(tokens, token{expr[i+1 : i+1+end], true})
+			i += end + 2
+		case strings.IndexByte("(),", c) != -1:
+			tokens = append(tokens, token{string(c), false})
+			i++
+		case strings.IndexByte("=!<>~", c) != -1:
+			op := string(c)
+			if i+1 < len(expr) && ((expr[i+1] == '=' && c != '=' && c != '~') || (expr[i+1] == '~' && c == '!')) {
+				op += string(expr[i+1])
+			}
+			tokens = append(tokens, token{op, false})
+			i += len(op)
+		default:
+			start := i
+			for i < len(expr) && strings.IndexByte(" \t\n\"(),=!<>~", expr[i]) == -1 {
+				i++
+			}
+			tokens = append(tokens, token{expr[start:i], false})
+		}
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	now    time.Time
+}
+
+func (p *parser) peek() string {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].quoted {
+		return ""
+	}
+	return strings.ToLower(p.tokens[p.pos].text)
+}
+
+func (p *parser) next() (token, error) {
+	if p.pos >= len(p.tokens) {
+		return token{}, errors.New("Unexpected end of query")
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *parser) or() (predicate, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "or" {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = or{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (predicate, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "and" {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = and{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (predicate, error) {
+	switch p.peek() {
+	case "not":
+		p.pos++
+		inner, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return not{inner}, nil
+	case "(":
+		p.pos++
+		inner, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("Missing ) in query")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (predicate, error) {
+	field, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	name := strings.ToLower(field.text)
+	numeric, ok := queryFields[name]
+	if field.quoted || !ok {
+		return nil, errors.New("Unknown field " + field.text + " in query")
+	}
+	op, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	c := comparison{field: name, op: op.text, text: value.text}
+	switch op.text {
+	case "=", "!=":
+	case "<", "<=", ">", ">=":
+		if !numeric {
+			return nil, errors.New("Can not use " + op.text + " on " + name)
+		}
+	case "~", "!~":
+		if numeric {
+			return nil, errors.New("Can not use " + op.text + " on " + name)
+		}
+		if c.re, err = regexp.Compile(value.text); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Expected an operator after " + name + " but got " + op.text)
+	}
+	if numeric {
+		if c.num, err = p.number(name, value.text); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *parser) number(field, value string) (int64, error) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return n, nil
+	}
+	switch field {
+	case "start":
+		return parseTime(value, p.now)
+	case "duration":
+		if d, err := time.ParseDuration(value); err == nil {
+			return int64(d / time.Millisecond), nil
+		}
+	}
+	return 0, errors.New("Invalid " + field + " " + value + " in query")
+}
+
+// pushdown moves the conditions of a chain of ands that the stores can
+// handle to q, anything else is left to the filter
+func pushdown(p predicate, q *BuildQuery) {
+	switch p := p.(type) {
+	case and:
+		pushdown(p.left, q)
+		pushdown(p.right, q)
+	case comparison:
+		switch {
+		case p.op == "=" && p.field == "server":
+			q.Server = p.text
+		case p.op == "=" && p.field == "job":
+			q.Job = p.text
+		case p.op == "=" && p.field == "host":
+			q.Host = p.text
+		case p.op == "=" && p.field == "result":
+			q.Result = p.text
+		case p.field == "start" && (p.op == ">" || p.op == ">="):
+			from := p.num
+			if p.op == ">" {
+				from++
+			}
+			if from > q.From {
+				q.From = from
+			}
+		case p.field == "start" && (p.op == "<" || p.op == "<="):
+			to := p.num
+			if p.op == "<=" {
+				to++
+			}
+			if q.To == 0 || to < q.To {
+				q.To = to
+			}
+		}
+	}
+}
+
+// ParseQuery compiles an expression, an empty one selects every build
+func ParseQuery(expr string, now time.Time) (Query, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return Query{}, err
+	}
+	var group []string
+	for i := 0; i+1 < len(tokens); i++ {
+		if !tokens[i].quoted && !tokens[i+1].quoted &&
+			strings.ToLower(tokens[i].text) == "group" && strings.ToLower(tokens[i+1].text) == "by" {
+			for _, t := range tokens[i+2:] {
+				if t.text == "," {
+					continue
+				}
+				name := strings.ToLower(t.text)
+				if _, ok := queryFields[name]; !ok || t.quoted {
+					return Query{}, errors.New("Can not group by " + t.text)
+				}
+				group = append(group, name)
+			}
+			if len(group) == 0 {
+				return Query{}, errors.New("Missing fields after group by")
+			}
+			tokens = tokens[0:i]
+			break
+		}
+	}
+	query := Query{GroupBy: group}
+	if len(tokens) == 0 {
+		return query, nil
+	}
+	p := &parser{tokens: tokens, now: now}
+	if query.Filter, err = p.or(); err != nil {
+		return Query{}, err
+	}
+	if p.pos < len(tokens) {
+		return Query{}, errors.New("Unexpected " + tokens[p.pos].text + " in query")
+	}
+	pushdown(query.Filter, &query.Store)
+	return query, nil
+}
+
+func (q Query) Run(store BuildStore) ([]Build, error) {
+	all, err := store.QueryBuilds(q.Store)
+	if err != nil {
+		return nil, err
+	}
+	if q.Filter == nil {
+		return all, nil
+	}
+	var builds []Build
+	for _, build := range all {
+		if q.Filter.match(build) {
+			builds = append(builds, build)
+		}
+	}
+	return builds, nil
+}
+
+// Table lists the builds or, with group by, counts and failure rates per
+// group ordered by the group values
+func (q Query) Table(builds []Build) Table {
+	if len(q.GroupBy) == 0 {
+		t := Table{Headers: []string{"Server", "Job", "Number", "Host", "Result", "Start", "Duration", "Failed", "Total"}}
+		for _, b := range builds {
+			t.Add(b.Server, b.Job, b.Number, b.Host, b.Result, formatStart(b.Start), msDuration(b.Duration), b.Failed, b.Total)
+		}
+		return t
+	}
+	type group struct {
+		values    []string
+		builds    int
+		failures  int
+		durations []int64
+	}
+	groups := make(map[string]*group)
+	var keys []string
+	for _, b := range builds {
+		var values []string
+		for _, field := range q.GroupBy {
+			values = append(values, fieldValue(b, field))
+		}
+		key := strings.Join(values, "\x00")
+		g, ok := groups[key]
+		if !ok {
+			g = &group{values: values}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.builds++
+		if b.Result == "FAILURE" {
+			g.failures++
+		}
+		if b.Duration >= 0 {
+			g.durations = append(g.durations, b.Duration)
+		}
+	}
+	sort.Strings(keys)
+	headers := make([]string, len(q.GroupBy))
+	for i, field := range q.GroupBy {
+		headers[i] = strings.ToUpper(field[0:1]) + field[1:]
+	}
+	t := Table{Headers: append(headers, "Builds", "Failures", "FailureRate", "MedianDuration")}
+	for _, key := range keys {
+		g := groups[key]
+		var row []interface{}
+		for _, v := range g.values {
+			row = append(row, v)
+		}
+		row = append(row, g.builds, g.failures, percent(ratio(g.failures, g.builds)), msDuration(median(g.durations)))
+		t.Add(row...)
+	}
+	return t
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func queryStore() *MemoryStore {
+	store := NewMemoryStore()
+	store.InsertBuild(Build{"http://fake/jenkins", "job1", 1, 1000, 60000, "linux-2.6-a", "FAILURE", 1, 10})
+	store.InsertBuild(Build{"http://fake/jenkins", "job1", 2, 2000, 120000, "linux-2.6-a", "SUCCESS", 0, 10})
+	store.InsertBuild(Build{"http://fake/jenkins", "job1", 3, 3000, 60000, "linux-3.0", "FAILURE", 2, 10})
+	store.InsertBuild(Build{"http://fake/jenkins", "job2", 1, 4000, 30000, "linux-2.6-b", "FAILURE", -1, -1})
+	return store
+}
+
+func runQuery(t *testing.T, expr string) (Query, []Build) {
+	query, err := ParseQuery(expr, time.Unix(10, 0))
+	if err != nil {
+		t.Fatalf("Could not parse %s: %s", expr, err.Error())
+	}
+	builds, err := query.Run(queryStore())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return query, builds
+}
+
+func TestQueryFilter(t *testing.T) {
+	for expr, expected := range map[string]int{
+		"":                                      4,
+		`result=FAILURE and host~"linux-2.6"`:   2,
+		"result=FAILURE and not host~linux-2.6": 1,
+		"job=job2 or (number>=2 and failed>0)":  2,
+		"duration>=1m and duration<2m":          2,
+		"start>1970-01-01T00:00:02Z":            2,
+		"start>9s":                              3,
+		"result!=FAILURE":                       1,
+		`host!~"^linux-2"`:                      1,
+		"total=-1":                              1,
+	} {
+		if _, builds := runQuery(t, expr); len(builds) != expected {
+			t.Fatalf("Expected %d builds for %q but got %v", expected, expr, builds)
+		}
+	}
+}
+
+func TestQueryPushdown(t *testing.T) {
+	query, _ := runQuery(t, "result=FAILURE and job=job1 and start>=2000 and start<5000 and host~linux")
+	if query.Store != (BuildQuery{Job: "job1", Result: "FAILURE", From: 2000, To: 5000}) {
+		t.Fatalf("Unexpected store query %+v", query.Store)
+	}
+	query, _ = runQuery(t, "result=FAILURE or job=job1")
+	if query.Store != (BuildQuery{}) {
+		t.Fatalf("Expected nothing pushed down through or but got %+v", query.Store)
+	}
+}
+
+func TestQueryGroupBy(t *testing.T) {
+	query, builds := runQuery(t, `host~"linux-2.6" group by host`)
+	table := query.Table(builds)
+	if len(table.Rows) != 2 || table.Headers[0] != "Host" {
+		t.Fatalf("Unexpected table %v", table)
+	}
+	row := table.Rows[0]
+	if row[0] != "linux-2.6-a" || row[1] != "2" || row[2] != "1" || row[3] != "50.0%" || row[4] != "1m0s" {
+		t.Fatalf("Unexpected row %v", row)
+	}
+}
+
+func TestQueryErrors(t *testing.T) {
+	for _, expr := range []string{
+		"colour=red",
+		"host>2",
+		"number~1",
+		"number=abc",
+		"(result=FAILURE",
+		"result=FAILURE group by",
+		"result=FAILURE host=x",
+		`host="unterminated`,
+	} {
+		if _, err := ParseQuery(expr, time.Now()); err == nil {
+			t.Fatalf("Expected %q to fail", expr)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jwiklund/jenkins"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Rule picks jobs on a server to track, a job is tracked when it matches
+// an include rule and no exclude rule
+type Rule struct {
+	Server  string
+	Pattern string
+	Exclude bool
+}
+
+func (r Rule) String() string {
+	if r.Exclude {
+		return "exclude " + r.Pattern + " on " + r.Server
+	}
+	return "include " + r.Pattern + " on " + r.Server
+}
+
+// Tracked tells if the rules pick up name on server, patterns that do not
+// compile never match
+func Tracked(rules []Rule, server, name string) bool {
+	included := false
+	for _, rule := range rules {
+		if rule.Server != server {
+			continue
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil || !re.MatchString(name) {
+			continue
+		}
+		if rule.Exclude {
+			return false
+		}
+		included = true
+	}
+	return included
+}
+
+func AddRule(j jenkins.Jenkins, store BuildStore, pattern string, exclude bool) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		fmt.Println("Invalid pattern " + pattern + ": " + err.Error())
+		return
+	}
+	rule := Rule{j.Server(), pattern, exclude}
+	if err := store.PutRule(rule); err != nil {
+		fmt.Println("Could not add rule ", err)
+		return
+	}
+	fmt.Println("Added rule " + rule.String())
+}
+
+func RemoveRule(j jenkins.Jenkins, store BuildStore, pattern string) {
+	if err := store.DeleteRule(j.Server(), pattern); err != nil {
+		fmt.Println("Could not remove rule ", err)
+		return
+	}
+	fmt.Println("Removed rule " + pattern)
+}
+
+func ListRules(store BuildStore) {
+	rules, err := store.GetRules()
+	if err != nil {
+		fmt.Println("Could not load rules ", err)
+		return
+	}
+	for _, rule := range rules {
+		fmt.Println(rule.String())
+	}
+}
+
+// SyncJobs starts tracking jobs on the server that match the rules, marks
+// stored jobs that are gone from the server as archived and restores
+// archived jobs that are back. An empty job list or one missing more than
+// half of the jobs tracked before this sync is more likely a permission or
+// view problem than deleted jobs, so nothing is archived then unless force
+// is set.
+func SyncJobs(j jenkins.Jenkins, store BuildStore, force bool) error {
+	current, err := GetJobs(j, "")
+	if err != nil {
+		return err
+	}
+	rules, err := store.GetRules()
+	if err != nil {
+		return errors.New("Could not load rules: " + err.Error())
+	}
+	stored, err := store.GetJobs()
+	if err != nil {
+		return errors.New("Could not load jobs: " + err.Error())
+	}
+	known := make(map[string]Job)
+	active := 0
+	for _, job := range stored {
+		if job.Server == j.Server() {
+			known[job.Name] = job
+			if job.Archived == 0 {
+				active++
+			}
+		}
+	}
+	exists := make(map[string]bool)
+	for _, job := range current {
+		exists[job.Name] = true
+		old, ok := known[job.Name]
+		if !ok && Tracked(rules, job.Server, job.Name) {
+			if err := store.PutJob(job); err != nil {
+				return err
+			}
+			fmt.Println("Tracking new job " + job.Name)
+		} else if ok && old.Archived != 0 {
+			if err := store.ArchiveJob(old, 0); err != nil {
+				return err
+			}
+			fmt.Println("Restored job " + job.Name)
+		}
+	}
+	var missing []Job
+	for _, job := range stored {
+		if job.Server == j.Server() && job.Archived == 0 && !exists[job.Name] {
+			missing = append(missing, job)
+		}
+	}
+	if len(missing) > 0 && !force && (len(current) == 0 || 2*len(missing) > active) {
+		return errors.New("Refusing to archive " + strconv.Itoa(len(missing)) + " of " + strconv.Itoa(active) +
+			" tracked jobs missing from " + j.Server() + ", use -archive-missing if they really are deleted")
+	}
+	now := time.Now().Unix()
+	for _, job := range missing {
+		if err := store.ArchiveJob(job, now); err != nil {
+			return err
+		}
+		fmt.Println("Archived deleted job " + job.Name)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"github.com/jwiklund/jenkins"
+	"testing"
+)
+
+func TestTracked(t *testing.T) {
+	rules := []Rule{
+		{"http://fake/jenkins", "^app-", false},
+		{"http://fake/jenkins", "-experimental$", true},
+		{"http://other/jenkins", ".*", false},
+	}
+	for name, expected := range map[string]bool{
+		"app-build":              true,
+		"app-build-experimental": false,
+		"lib-build":              false,
+	} {
+		if Tracked(rules, "http://fake/jenkins", name) != expected {
+			t.Fatalf("Expected %s tracked to be %v", name, expected)
+		}
+	}
+}
+
+func TestSyncJobs(t *testing.T) {
+	j := newFake()
+	store := NewMemoryStore()
+	SaveJobs(j, store, []string{"job1", "job2"})
+	AddRule(j, store, "^job", false)
+	AddRule(j, store, "3", true)
+	j.jobs = []jenkins.Job{{Name: "job2"}, {Name: "job3"}, {Name: "job4"}}
+	RefreshBuilds(context.Background(), j, store, RefreshOptions{})
+	jobs, _ := store.GetJobs()
+	if len(jobs) != 3 || jobs[0].Name != "job1" || jobs[1].Name != "job2" || jobs[2].Name != "job4" {
+		t.Fatalf("Expected job1, job2 and the new job4 but got %v", jobs)
+	}
+	if jobs[0].Archived == 0 || jobs[1].Archived != 0 || jobs[2].Archived != 0 {
+		t.Fatalf("Expected only the deleted job1 to be archived but got %v", jobs)
+	}
+	j.jobs = append(j.jobs, jenkins.Job{Name: "job1"})
+	if err := SyncJobs(j, store, false); err != nil {
+		t.Fatal(err.Error())
+	}
+	job, _ := store.GetJob("http://fake/jenkins", "job1")
+	if job.Archived != 0 {
+		t.Fatalf("Expected job1 to be restored but got %v", job)
+	}
+}
+
+func TestSyncJobsRefusesMassArchive(t *testing.T) {
+	for name, current := range map[string][]jenkins.Job{
+		"empty":   nil,
+		"most":    {{Name: "job1"}},
+		"nearly":  {{Name: "job1"}, {Name: "job9"}},
+		"renamed": {{Name: "job1"}, {Name: "job4"}, {Name: "job5"}},
+	} {
+		j := newFake()
+		store := NewMemoryStore()
+		j.jobs = []jenkins.Job{{Name: "job1"}, {Name: "job2"}, {Name: "job3"}}
+		SaveJobs(j, store, []string{"job1", "job2", "job3"})
+		AddRule(j, store, "^job", false)
+		j.jobs = current
+		if err := SyncJobs(j, store, false); err == nil {
+			t.Fatalf("%s: Expected archiving to be refused", name)
+		}
+		jobs, _ := store.GetJobs()
+		for _, job := range jobs {
+			if job.Archived != 0 {
+				t.Fatalf("%s: Expected nothing archived but got %v", name, jobs)
+			}
+		}
+		if err := SyncJobs(j, store, true); err != nil {
+			t.Fatalf("%s: %s", name, err.Error())
+		}
+		job, _ := store.GetJob("http://fake/jenkins", "job3")
+		if job.Archived == 0 {
+			t.Fatalf("%s: Expected job3 archived when forced but got %v", name, job)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server serves the store over http, a JSON api under /api/ and a few
+// html pages for people. It only reads so it does not lock the store.
+// threshold, window and slower are the -hosts and -trends settings.
+type Server struct {
+	store     BuildStore
+	threshold float64
+	window    int
+	slower    float64
+	mux       *http.ServeMux
+}
+
+func NewServer(store BuildStore, threshold float64, window int, slower float64) *Server {
+	s := &Server{store: store, threshold: threshold, window: window, slower: slower, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/api/jobs", s.apiJobs)
+	s.mux.HandleFunc("/api/builds", s.apiBuilds)
+	s.mux.HandleFunc("/api/hosts", s.apiHosts)
+	s.mux.HandleFunc("/api/stats", s.apiStats)
+	s.mux.HandleFunc("/job", s.jobPage)
+	s.mux.HandleFunc("/heatmap", s.heatmapPage)
+	s.mux.HandleFunc("/", s.indexPage)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		fmt.Println("Could not write response ", err)
+	}
+}
+
+// builds runs the query parameter (everything when missing) limited to
+// builds started within since, 7d by default
+func (s *Server) builds(r *http.Request) ([]Build, error) {
+	query, err := ParseQuery(r.FormValue("query"), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	since := r.FormValue("since")
+	if since == "" {
+		since = "7d"
+	}
+	from, err := parseTime(since, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if from > query.Store.From {
+		query.Store.From = from
+	}
+	return query.Run(s.store)
+}
+
+// hostReport runs the host report on builds with the stored causes
+func (s *Server) hostReport(builds []Build) ([]HostStats, error) {
+	causes, err := s.store.GetCauses()
+	if err != nil {
+		return nil, err
+	}
+	return HostReport(builds, causeMap(causes), s.threshold), nil
+}
+
+func (s *Server) apiJobs(w http.ResponseWriter, r *http.Request) {
+	jobs, err := s.store.GetJobs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, jobs)
+}
+
+func (s *Server) apiBuilds(w http.ResponseWriter, r *http.Request) {
+	builds, err := s.builds(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit, err := strconv.Atoi(r.FormValue("limit")); err == nil && limit >= 0 && limit < len(builds) {
+		builds = builds[len(builds)-limit:]
+	}
+	if builds == nil {
+		builds = []Build{}
+	}
+	writeJSON(w, builds)
+}
+
+func (s *Server) apiHosts(w http.ResponseWriter, r *http.Request) {
+	builds, err := s.builds(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	stats, err := s.hostReport(builds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if stats == nil {
+		stats = []HostStats{}
+	}
+	writeJSON(w, stats)
+}
+
+// BuildStats counts the stored finished builds of a job on a host with a
+// result. Timed is the number with a known duration, Seconds their total
+// and Buckets how many of them took at most each requested bound.
+type BuildStats struct {
+	Job     string  `json:"job"`
+	Host    string  `json:"host"`
+	Result  string  `json:"result"`
+	Builds  int     `json:"builds"`
+	Timed   int     `json:"timed"`
+	Seconds float64 `json:"seconds"`
+	Buckets []int   `json:"buckets"`
+}
+
+// StatsOf aggregates builds for the exporter so it does not have to fetch
+// every build, bounds are in seconds and must be sorted
+func StatsOf(builds []Build, bounds []float64) []BuildStats {
+	index := make(map[string]int)
+	var stats []BuildStats
+	for _, b := range builds {
+		if b.Result == "" {
+			continue
+		}
+		key := b.Job + "\x00" + b.Host + "\x00" + b.Result
+		i, ok := index[key]
+		if !ok {
+			i = len(stats)
+			index[key] = i
+			stats = append(stats, BuildStats{Job: b.Job, Host: b.Host, Result: b.Result, Buckets: make([]int, len(bounds))})
+		}
+		stats[i].Builds++
+		if b.Duration < 0 {
+			continue
+		}
+		seconds := float64(b.Duration) / 1000
+		stats[i].Timed++
+		stats[i].Seconds += seconds
+		for j, bound := range bounds {
+			if seconds <= bound {
+				stats[i].Buckets[j]++
+			}
+		}
+	}
+	sort.Slice(stats, func(a, b int) bool {
+		if stats[a].Job != stats[b].Job {
+			return stats[a].Job < stats[b].Job
+		}
+		if stats[a].Host != stats[b].Host {
+			return stats[a].Host < stats[b].Host
+		}
+		return stats[a].Result < stats[b].Result
+	})
+	return stats
+}
+
+// apiStats aggregates every stored build, buckets is a comma separated
+// list of duration bounds in seconds
+func (s *Server) apiStats(w http.ResponseWriter, r *http.Request) {
+	var bounds []float64
+	if r.FormValue("buckets") != "" {
+		for _, field := range strings.Split(r.FormValue("buckets"), ",") {
+			bound, err := strconv.ParseFloat(field, 64)
+			if err != nil || (len(bounds) > 0 && bound <= bounds[len(bounds)-1]) {
+				http.Error(w, "Invalid buckets "+r.FormValue("buckets")+", use increasing seconds", http.StatusBadRequest)
+				return
+			}
+			bounds = append(bounds, bound)
+		}
+	}
+	builds, err := s.store.QueryBuilds(BuildQuery{})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	stats := StatsOf(builds, bounds)
+	if stats == nil {
+		stats = []BuildStats{}
+	}
+	writeJSON(w, stats)
+}
+
+const pageHead = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 8px; text-align: left; border-bottom: 1px solid #ddd; }
+td.cell { width: 24px; height: 18px; padding: 0; border: 1px solid #fff; }
+.flag { color: #c00; font-weight: bold; }
+</style></head><body>
+<p><a href="/">Failures</a> | <a href="/heatmap?since={{.Since}}">Heatmap</a> | <a href="/api/jobs">Jobs (json)</a></p>
+<h1>{{.Title}}</h1>
+<form><input name="since" value="{{.Since}}" size="6"> <input name="query" value="{{.Query}}" size="60"> <input type="submit" value="Show"></form>
+`
+
+var pages = template.Must(template.New("index").Parse(pageHead + `
+<table>
+<tr><th>Host</th><th>Failures</th><th>Infra</th><th>Tests</th><th>Builds</th><th>Rate</th><th>Score</th><th>Latest failure</th></tr>
+{{range .Hosts}}<tr><td>{{.Host}}</td><td>{{.Failures}}</td><td>{{.Infra}}</td><td>{{.Tests}}</td><td>{{.Builds}}</td><td>{{.Rate}}</td>
+<td{{if .Flagged}} class="flag"{{end}}>{{.Score}}</td><td>{{.Latest}}</td></tr>
+{{end}}</table>
+<h2>Jobs</h2>
+<ul>{{range .Jobs}}<li><a href="/job?server={{.Server}}&amp;name={{.Name}}&amp;since={{$.Since}}">{{.Name}}</a>{{if .Archived}} (archived){{end}}</li>
+{{end}}</ul>
+</body></html>`))
+
+func init() {
+	template.Must(pages.New("job").Parse(pageHead + `
+<p>{{.Trend.Builds}} builds, median {{.Baseline}} then {{.Current}}{{if .Trend.Regression}}, <span class="flag">slower since #{{.Trend.Step}}</span>{{end}}</p>
+<svg width="{{.Width}}" height="{{.Height}}" style="border: 1px solid #ddd">
+<polyline fill="none" stroke="#36c" stroke-width="1.5" points="{{.Points}}"/>
+{{range .Failures}}<circle cx="{{.X}}" cy="{{.Y}}" r="3" fill="#c00"><title>{{.Title}}</title></circle>
+{{end}}</svg>
+<p>Longest {{.Max}}, failed builds in red.</p>
+</body></html>`))
+	template.Must(pages.New("heatmap").Parse(pageHead + `
+<table>
+<tr><th>Host</th>{{range .Days}}<th title="{{.}}">{{slice . 8}}</th>{{end}}</tr>
+{{range .Rows}}<tr><td>{{.Host}}</td>{{range .Cells}}<td class="cell" style="background: {{.Color}}" title="{{.Title}}"></td>{{end}}</tr>
+{{end}}</table>
+<p>Green is no failures, red is every build failing, grey is no builds.</p>
+</body></html>`))
+}
+
+type page struct {
+	Title string
+	Since string
+	Query string
+}
+
+func newPage(title string, r *http.Request) page {
+	since := r.FormValue("since")
+	if since == "" {
+		since = "7d"
+	}
+	return page{title, since, r.FormValue("query")}
+}
+
+func (s *Server) render(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := pages.ExecuteTemplate(w, name, data); err != nil {
+		fmt.Println("Could not render "+name, err)
+	}
+}
+
+func (s *Server) indexPage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	builds, err := s.builds(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jobs, err := s.store.GetJobs()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	latest := make(map[string]Build)
+	for _, b := range builds {
+		if b.Result == "FAILURE" && b.Start > latest[hostName(b)].Start {
+			latest[hostName(b)] = b
+		}
+	}
+	stats, err := s.hostReport(builds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	type hostRow struct {
+		Host        string
+		Failures    int
+		Infra       int
+		Tests       int
+		Builds      int
+		Rate, Score string
+		Flagged     bool
+		Latest      string
+	}
+	var hosts []hostRow
+	for _, h := range stats {
+		if h.Failures == 0 {
+			continue
+		}
+		b := latest[h.Host]
+		hosts = append(hosts, hostRow{h.Host, h.Failures, h.Infra, h.Tests, h.Builds, percent(h.FailureRate()),
+			strconv.FormatFloat(h.Score, 'f', 1, 64), h.Flagged, b.Job + " #" + strconv.Itoa(b.Number) + " " + formatStart(b.Start)})
+	}
+	sort.SliceStable(hosts, func(a, b int) bool { return hosts[a].Failures > hosts[b].Failures })
+	s.render(w, "index", struct {
+		page
+		Hosts []hostRow
+		Jobs  []Job
+	}{newPage("Recent failures by host", r), hosts, jobs})
+}
+
+type point struct {
+	X, Y  int
+	Title string
+}
+
+func (s *Server) jobPage(w http.ResponseWriter, r *http.Request) {
+	p := newPage(r.FormValue("name"), r)
+	from, err := parseTime(p.Since, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	builds, err := s.store.QueryBuilds(BuildQuery{Server: r.FormValue("server"), Job: r.FormValue("name"), From: from})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	const width, height = 800, 200
+	var max int64 = 1
+	for _, b := range builds {
+		if b.Duration > max {
+			max = b.Duration
+		}
+	}
+	var points []string
+	var failures []point
+	for i, b := range builds {
+		x := 5
+		if len(builds) > 1 {
+			x = 5 + i*(width-10)/(len(builds)-1)
+		}
+		y := height - 5 - int(b.Duration*(height-10)/max)
+		points = append(points, strconv.Itoa(x)+","+strconv.Itoa(y))
+		if b.Result == "FAILURE" {
+			failures = append(failures, point{x, y, "#" + strconv.Itoa(b.Number) + " on " + b.Host})
+		}
+	}
+	trend := JobTrend(builds, s.window, s.slower, 0)
+	s.render(w, "job", struct {
+		page
+		Trend             Trend
+		Baseline, Current string
+		Width, Height     int
+		Points            string
+		Failures          []point
+		Max               string
+	}{p, trend, msDuration(trend.Baseline), msDuration(trend.Current), width, height,
+		strings.Join(points, " "), failures, msDuration(max)})
+}
+
+type heatCell struct {
+	Color template.CSS
+	Title string
+}
+
+// heatColor goes from green for no failures to red when all builds failed
+func heatColor(failures, builds int) string {
+	if builds == 0 {
+		return "#eee"
+	}
+	hue := int(120 * (1 - ratio(failures, builds)))
+	return "hsl(" + strconv.Itoa(hue) + ", 70%, 50%)"
+}
+
+func (s *Server) heatmapPage(w http.ResponseWriter, r *http.Request) {
+	p := newPage("Host health", r)
+	builds, err := s.builds(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	type count struct{ builds, failures int }
+	counts := make(map[string]map[string]*count)
+	daySet := make(map[string]bool)
+	for _, b := range builds {
+		if b.Result == "" || b.Start <= 0 {
+			continue
+		}
+		day := time.Unix(b.Start/1000, 0).Format("2006-01-02")
+		daySet[day] = true
+		host := hostName(b)
+		if counts[host] == nil {
+			counts[host] = make(map[string]*count)
+		}
+		c := counts[host][day]
+		if c == nil {
+			c = &count{}
+			counts[host][day] = c
+		}
+		c.builds++
+		if b.Result == "FAILURE" {
+			c.failures++
+		}
+	}
+	var days, hosts []string
+	for day := range daySet {
+		days = append(days, day)
+	}
+	for host := range counts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(days)
+	sort.Strings(hosts)
+	type heatRow struct {
+		Host  string
+		Cells []heatCell
+	}
+	var rows []heatRow
+	for _, host := range hosts {
+		row := heatRow{Host: host}
+		for _, day := range days {
+			c := counts[host][day]
+			if c == nil {
+				c = &count{}
+			}
+			row.Cells = append(row.Cells, heatCell{template.CSS(heatColor(c.failures, c.builds)),
+				day + ": " + strconv.Itoa(c.failures) + " of " + strconv.Itoa(c.builds) + " failed"})
+		}
+		rows = append(rows, row)
+	}
+	s.render(w, "heatmap", struct {
+		page
+		Days []string
+		Rows []heatRow
+	}{p, days, rows})
+}
+
+func Serve(store BuildStore, addr string, threshold float64, window int, slower float64) {
+	fmt.Println("Serving on " + addr)
+	if err := http.ListenAndServe(addr, NewServer(store, threshold, window, slower)); err != nil {
+		fmt.Println("Could not serve ", err)
+	}
+}
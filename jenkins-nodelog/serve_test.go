@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveStore() *MemoryStore {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	store := NewMemoryStore()
+	store.PutJob(Job{"http://fake/jenkins", "job1", "http://fake/jenkins/job/job1/", 0})
+	store.InsertBuild(Build{"http://fake/jenkins", "job1", 1, now - 3600000, 60000, "host1", "SUCCESS", 0, 10})
+	store.InsertBuild(Build{"http://fake/jenkins", "job1", 2, now - 1800000, 90000, "host2", "FAILURE", 1, 10})
+	store.InsertBuild(Build{"http://fake/jenkins", "job1", 3, now - 30*24*3600000, 60000, "host2", "FAILURE", 1, 10})
+	return store
+}
+
+func get(t *testing.T, server *httptest.Server, path string) (int, string) {
+	resp, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestServeAPI(t *testing.T) {
+	server := httptest.NewServer(NewServer(serveStore(), 2, 10, 0.3))
+	defer server.Close()
+	_, body := get(t, server, "/api/jobs")
+	var jobs []Job
+	if err := json.Unmarshal([]byte(body), &jobs); err != nil || len(jobs) != 1 || jobs[0].Name != "job1" {
+		t.Fatalf("Unexpected jobs %s", body)
+	}
+	_, body = get(t, server, "/api/builds?query="+url.QueryEscape("result=FAILURE"))
+	var builds []Build
+	if err := json.Unmarshal([]byte(body), &builds); err != nil || len(builds) != 1 || builds[0].Number != 2 {
+		t.Fatalf("Expected the recent failure but got %s", body)
+	}
+	_, body = get(t, server, "/api/builds?since=60d&limit=2")
+	if err := json.Unmarshal([]byte(body), &builds); err != nil || len(builds) != 2 {
+		t.Fatalf("Expected 2 builds but got %s", body)
+	}
+	_, body = get(t, server, "/api/hosts")
+	var hosts []HostStats
+	if err := json.Unmarshal([]byte(body), &hosts); err != nil || len(hosts) != 2 {
+		t.Fatalf("Unexpected hosts %s", body)
+	}
+	if status, _ := get(t, server, "/api/builds?query=colour=red"); status != http.StatusBadRequest {
+		t.Fatalf("Expected bad request for an invalid query but got %d", status)
+	}
+	_, body = get(t, server, "/api/stats?buckets=60,300")
+	var stats []BuildStats
+	if err := json.Unmarshal([]byte(body), &stats); err != nil || len(stats) != 2 {
+		t.Fatalf("Expected stats for host1 and host2 but got %s", body)
+	}
+	if stats[1].Host != "host2" || stats[1].Builds != 2 || stats[1].Seconds != 150 || stats[1].Buckets[0] != 1 || stats[1].Buckets[1] != 2 {
+		t.Fatalf("Expected both host2 failures counted but got %+v", stats[1])
+	}
+	if status, _ := get(t, server, "/api/stats?buckets=300,60"); status != http.StatusBadRequest {
+		t.Fatalf("Expected bad request for unsorted buckets but got %d", status)
+	}
+}
+
+func TestServePages(t *testing.T) {
+	server := httptest.NewServer(NewServer(serveStore(), 2, 10, 0.3))
+	defer server.Close()
+	for path, expected := range map[string]string{
+		"/": "job1 #2",
+		"/job?server=http://fake/jenkins&name=job1": "<polyline",
+		"/heatmap": "hsl(0, 70%, 50%)",
+	} {
+		status, body := get(t, server, path)
+		if status != http.StatusOK || !strings.Contains(body, expected) {
+			t.Fatalf("Expected %s to contain %q but got %d %s", path, expected, status, body)
+		}
+	}
+	if status, _ := get(t, server, "/missing"); status != http.StatusNotFound {
+		t.Fatalf("Expected not found but got %d", status)
+	}
+}
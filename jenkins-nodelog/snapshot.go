@@ -0,0 +1,253 @@
+package main
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A snapshot is a SQLite store, whatever the backend it was taken from,
+// compressed with gzip (.gz) or not at all. The migrations table inside it
+// is the format version, so restoring an old snapshot such as
+// archived-data/data.v1.bz2 runs the same migrations as opening an old
+// store does. There is no bzip2 writer in the standard library so .bz2
+// snapshots can be restored but not written.
+
+// copyRollups adds the rollups that to does not have a row for, so copying
+// twice does not count them twice
+func copyRollups(from, to BuildStore) error {
+	rollups, err := from.GetRollups()
+	if err != nil {
+		return err
+	}
+	existing, err := to.GetRollups()
+	if err != nil {
+		return err
+	}
+	known := make(map[Rollup]bool)
+	for _, r := range existing {
+		r.Builds, r.Duration = 0, 0
+		known[r] = true
+	}
+	for _, r := range rollups {
+		key := r
+		key.Builds, key.Duration = 0, 0
+		if known[key] {
+			continue
+		}
+		if err := to.AddRollup(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyStore adds the rules, rollups, jobs, builds, build metadata and
+// failure causes of from to to, builds that are already in to are
+// overwritten
+func CopyStore(from, to BuildStore) (int, error) {
+	rules, err := from.GetRules()
+	if err != nil {
+		return 0, err
+	}
+	existing, err := to.GetRules()
+	if err != nil {
+		return 0, err
+	}
+	for _, rule := range rules {
+		found := false
+		for _, other := range existing {
+			found = found || (other.Server == rule.Server && other.Pattern == rule.Pattern)
+		}
+		if !found {
+			if err := to.PutRule(rule); err != nil {
+				return 0, err
+			}
+		}
+	}
+	retention, err := from.GetRetention()
+	if err != nil {
+		return 0, err
+	}
+	for _, rule := range retention {
+		if err := to.PutRetention(rule); err != nil {
+			return 0, err
+		}
+	}
+	if err := copyRollups(from, to); err != nil {
+		return 0, err
+	}
+	jobs, err := from.GetJobs()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, job := range jobs {
+		if _, err := to.GetJob(job.Server, job.Name); err != nil {
+			if err := to.PutJob(job); err != nil {
+				return count, err
+			}
+		}
+		stored, err := to.GetBuilds(job.Server, job.Name)
+		if err != nil {
+			return count, err
+		}
+		known := make(map[int]bool)
+		for _, build := range stored {
+			known[build.Number] = true
+		}
+		builds, err := from.GetBuilds(job.Server, job.Name)
+		if err != nil {
+			return count, err
+		}
+		for _, build := range builds {
+			if known[build.Number] {
+				err = to.UpdateBuild(build)
+			} else {
+				err = to.InsertBuild(build)
+			}
+			if err != nil {
+				return count, err
+			}
+			count++
+		}
+		metas, err := from.GetMetas(job.Server, job.Name)
+		if err != nil {
+			return count, err
+		}
+		for _, meta := range metas {
+			if err := to.PutMeta(meta); err != nil {
+				return count, err
+			}
+		}
+	}
+	causes, err := from.GetCauses()
+	if err != nil {
+		return count, err
+	}
+	for _, cause := range causes {
+		if err := to.PutCause(cause); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func snapshotReader(path string, f *os.File) (io.Reader, error) {
+	switch {
+	case strings.HasSuffix(path, ".bz2"):
+		return bzip2.NewReader(f), nil
+	case strings.HasSuffix(path, ".gz"):
+		return gzip.NewReader(f)
+	}
+	return f, nil
+}
+
+// compress writes the file at from to path, gzipped for .gz names
+func compress(from, path string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	var gz *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		gz = gzip.NewWriter(out)
+		w = gz
+	}
+	_, err = io.Copy(w, in)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func Snapshot(store BuildStore, path string) error {
+	if strings.HasSuffix(path, ".bz2") {
+		return errors.New("Can not write bzip2 snapshots, use a .gz name (.bz2 snapshots can still be restored)")
+	}
+	dir, err := os.MkdirTemp("", "nodelog")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "snapshot.db")
+	snapshot, err := OpenSQLite(tmp)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+	if err := snapshot.Begin(); err != nil {
+		return err
+	}
+	count, err := CopyStore(store, snapshot)
+	if err != nil {
+		return errors.New("Could not copy store: " + err.Error())
+	}
+	if err := snapshot.Commit(); err != nil {
+		return err
+	}
+	snapshot.Close()
+	if err := compress(tmp, path); err != nil {
+		return err
+	}
+	fmt.Println("Wrote", count, "builds to", path)
+	return nil
+}
+
+func Restore(store BuildStore, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	in, err := snapshotReader(path, f)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "nodelog")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "snapshot.db")
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	out.Close()
+	if err != nil {
+		return errors.New("Could not read " + path + ": " + err.Error())
+	}
+	snapshot, err := OpenSQLite(tmp)
+	if err != nil {
+		return errors.New("Could not open snapshot: " + err.Error())
+	}
+	defer snapshot.Close()
+	if err := store.Begin(); err != nil {
+		return err
+	}
+	count, err := CopyStore(snapshot, store)
+	if err != nil {
+		return errors.New("Could not restore: " + err.Error())
+	}
+	if err := store.Commit(); err != nil {
+		return err
+	}
+	fmt.Println("Restored", count, "builds from", path)
+	return nil
+}
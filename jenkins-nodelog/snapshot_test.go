@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	store := NewMemoryStore()
+	store.PutRule(Rule{"http://fake/jenkins", "^job", false})
+	store.PutRetention(Retention{"^job", 30, 0, 0})
+	store.AddRollup(Rollup{"http://fake/jenkins", "job1", "host1", "2013-01-01", "SUCCESS", 2, 100})
+	store.PutJob(Job{"http://fake/jenkins", "job1", "http://fake/jenkins/job/job1/", 0})
+	store.PutJob(Job{"http://fake/jenkins", "job2", "http://fake/jenkins/job/job2/", 1000})
+	store.InsertBuild(Build{"http://fake/jenkins", "job1", 1, 1000, 10, "host1", "SUCCESS", -1, -1})
+	store.InsertBuild(Build{"http://fake/jenkins", "job2", 1, 2000, 20, "host2", "", 1, 10})
+	path := filepath.Join(t.TempDir(), "snapshot.gz")
+	if err := Snapshot(store, path); err != nil {
+		t.Fatal(err.Error())
+	}
+	bz2 := filepath.Join(t.TempDir(), "snapshot.bz2")
+	if err := Snapshot(store, bz2); err == nil {
+		t.Fatal("Expected writing a .bz2 snapshot to fail")
+	}
+	if _, err := os.Stat(bz2); err == nil {
+		t.Fatal("Expected no .bz2 file to be created")
+	}
+	restored := NewMemoryStore()
+	restored.InsertBuild(Build{"http://fake/jenkins", "job2", 1, 2000, 20, "host2", "RUNNING", 1, 10})
+	for i := 0; i < 2; i++ {
+		if err := Restore(restored, path); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if rollups, _ := restored.GetRollups(); len(rollups) != 1 || rollups[0].Builds != 2 {
+		t.Fatalf("Expected rollups to be restored once but got %v", rollups)
+	}
+	if retention, _ := restored.GetRetention(); len(retention) != 1 {
+		t.Fatalf("Unexpected retention %v", retention)
+	}
+	jobs, _ := restored.GetJobs()
+	if len(jobs) != 2 || jobs[1].Archived != 1000 {
+		t.Fatalf("Unexpected jobs %v", jobs)
+	}
+	rules, _ := restored.GetRules()
+	if len(rules) != 1 {
+		t.Fatalf("Unexpected rules %v", rules)
+	}
+	before, _ := store.QueryBuilds(BuildQuery{})
+	after, _ := restored.QueryBuilds(BuildQuery{})
+	if len(before) != len(after) || before[0] != after[0] || before[1] != after[1] {
+		t.Fatalf("Expected %v but restored %v", before, after)
+	}
+}
+
+func TestRestoreV1(t *testing.T) {
+	store := NewMemoryStore()
+	if err := Restore(store, "archived-data/data.v1.bz2"); err != nil {
+		t.Fatal(err.Error())
+	}
+	info, _ := store.Info()
+	if info.Jobs != 33 || info.Builds != 173 {
+		t.Fatalf("Expected 33 jobs and 173 builds but got %+v", info)
+	}
+}
@@ -4,125 +4,201 @@ import (
 	"database/sql"
 	"errors"
 	_ "github.com/mattn/go-sqlite3"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
-type Store struct {
-	db *sql.DB
-	tx *sql.Tx
+// BuildStore is what the commands need from a place to keep jobs and builds
+type BuildStore interface {
+	GetJobs() ([]Job, error)
+	GetJob(server, name string) (Job, error)
+	PutJob(job Job) error
+	ArchiveJob(job Job, archived int64) error
+	GetRules() ([]Rule, error)
+	PutRule(rule Rule) error
+	DeleteRule(server, pattern string) error
+	GetBuilds(server, name string) ([]Build, error)
+	LastBuild(server, name string) (int, error)
+	QueryBuilds(query BuildQuery) ([]Build, error)
+	InsertBuild(build Build) error
+	UpdateBuild(build Build) error
+	DeleteBuild(build Build) error
+	GetRetention() ([]Retention, error)
+	PutRetention(rule Retention) error
+	DeleteRetention(pattern string) error
+	GetRollups() ([]Rollup, error)
+	AddRollup(rollup Rollup) error
+	GetCauses() ([]FailureCause, error)
+	PutCause(cause FailureCause) error
+	GetMetas(server, name string) ([]BuildMeta, error)
+	PutMeta(meta BuildMeta) error
+	Vacuum() error
+	LogFailure(job Job, message string) error
+	ClearFailure(job Job) error
+	FailedJobs() ([]Job, error)
+	Begin() error
+	Commit() error
+	Lock() error
+	Info() (StoreInfo, error)
+	Close()
 }
 
-func OpenStore(path string) (Store, error) {
-	db, err := sql.Open("sqlite3", "data")
-	if err != nil {
-		return Store{}, err
-	}
-	store := Store{db, nil}
-	if err = store.ensureVersionTable(); err != nil {
-		store.Close()
-		return Store{}, err
+// BuildQuery selects builds, empty fields and zero times match everything.
+// From and To are start times in milliseconds, To is exclusive.
+type BuildQuery struct {
+	Server string
+	Job    string
+	Host   string
+	Result string
+	From   int64
+	To     int64
+	// Running only selects builds that had no result when stored
+	Running bool
+}
+
+func (q BuildQuery) Match(b Build) bool {
+	return (q.Server == "" || q.Server == b.Server) &&
+		(q.Job == "" || q.Job == b.Job) &&
+		(q.Host == "" || q.Host == b.Host) &&
+		(q.Result == "" || q.Result == b.Result) &&
+		(q.From == 0 || b.Start >= q.From) &&
+		(q.To == 0 || b.Start < q.To) &&
+		(!q.Running || b.Result == "")
+}
+
+type StoreInfo struct {
+	Version int
+	Jobs    int
+	Builds  int
+	First   int64
+	Last    int64
+}
+
+// SQLStore keeps builds in SQLite or PostgreSQL
+type SQLStore struct {
+	db     *sql.DB
+	tx     *sql.Tx
+	driver string
+	path   string
+	lock   *os.File
+	conn   *sql.Conn
+}
+
+// DefaultStorePath follows the XDG base directory spec
+func DefaultStorePath() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".local", "share")
 	}
-	if err = store.ensureBuildTable(); err != nil {
-		store.Close()
-		return Store{}, err
+	return filepath.Join(dir, "jenkins-nodelog", "nodelog.db")
+}
+
+// OpenStore opens a PostgreSQL store for postgres:// urls, an in memory
+// store for "memory" and a SQLite file for anything else
+func OpenStore(path string) (BuildStore, error) {
+	if strings.HasPrefix(path, "postgres://") || strings.HasPrefix(path, "postgresql://") {
+		return OpenPostgres(path)
 	}
-	if err = store.ensureJobTable(); err != nil {
-		store.Close()
-		return Store{}, err
+	if path == "memory" {
+		return NewMemoryStore(), nil
 	}
-	return store, nil
+	return OpenSQLite(path)
 }
 
-func (s Store) ensureVersionTable() error {
-	_, err := s.exec("create table if not exists version(version)")
-	if err != nil {
-		return err
+func OpenSQLite(path string) (*SQLStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
 	}
-	vrows, err := s.query("select version from version")
+	db, err := sql.Open("sqlite3", path)
 	if err != nil {
-		return err
-	}
-	defer vrows.Close()
-	version := 0
-	if vrows.Next() {
-		err = vrows.Scan(&version)
-		if err != nil {
-			return err
-		}
+		return nil, err
 	}
-	if version == 0 {
-		trows, err := s.query("select name from sqlite_master where type = 'table' and name = 'builds'")
-		if err != nil {
-			return err
-		}
-		if trows.Next() {
-			version = 1
-		}
-		trows.Close()
-		_, err = s.exec("insert into versions values (?)", version)
-		return err
+	store := &SQLStore{db: db, driver: "sqlite3", path: path}
+	if err = store.migrate(); err != nil {
+		store.Close()
+		return nil, err
 	}
-	return nil
+	return store, nil
 }
 
-func (s Store) version() (int, error) {
-	rows, err := s.query("select version from version")
-	if err != nil {
-		return -1, err
+func (s *SQLStore) Close() {
+	if s.tx != nil {
+		s.tx.Rollback()
+		s.tx = nil
 	}
-	defer rows.Close()
-	if rows.Next() {
-		version := 0
-		err = rows.Scan(&version)
-		if err != nil {
-			return -1, err
-		}
-		return version, nil
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	s.db.Close()
+	if s.lock != nil {
+		s.lock.Close()
+		s.lock = nil
 	}
-	return -1, errors.New("version table not initialized")
 }
 
-func (s Store) ensureBuildTable() error {
-	version, err := s.version()
+// Lock takes an exclusive lock on a file next to the database so that two
+// writers can't run at the same time, it is released by Close
+func (s *SQLStore) Lock() error {
+	if s.driver == "postgres" {
+		return s.lockPostgres()
+	}
+	if s.lock != nil {
+		return nil
+	}
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return err
 	}
-	switch version {
-	case 0:
-		if _, err := s.exec("create table if not exists builds(job, number, start, duration, host, result, failed, total, primary key(job, number))"); err != nil {
-			return err
-		}
-		_, err = s.exec("update version set version = '2'")
-		return err
-	case 1:
-		if _, err := s.exec("alter table builds add column failed"); err != nil {
-			return err
-		}
-		if _, err := s.exec("alter table builds add column total"); err != nil {
-			return err
-		}
-		_, err = s.exec("update version set version = '2'")
-		return err
-	case 2:
-		return nil
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		return errors.New("Store " + s.path + " is locked by another process")
 	}
-	return errors.New("Unsupported version " + strconv.Itoa(version))
+	s.lock = f
+	return nil
 }
 
-func (s Store) ensureJobTable() error {
-	_, err := s.exec("create table if not exists jobs(name primary key, url)")
-	return err
+// lockMigrations waits for the writer lock, the returned function
+// releases it again
+func (s SQLStore) lockMigrations() (func(), error) {
+	if s.driver == "postgres" {
+		return s.lockPostgresMigrations()
+	}
+	f, err := os.OpenFile(s.path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
 }
 
-func (s *Store) Close() {
-	if s.tx != nil {
-		s.tx.Rollback()
-		s.tx = nil
+func (s SQLStore) Info() (StoreInfo, error) {
+	var info StoreInfo
+	var err error
+	if info.Version, err = s.version(); err != nil {
+		return info, err
 	}
-	s.db.Close()
+	if err = s.db.QueryRow("select count(*) from jobs").Scan(&info.Jobs); err != nil {
+		return info, err
+	}
+	var first, last sql.NullInt64
+	err = s.db.QueryRow("select count(*), min(start), max(start) from builds").Scan(&info.Builds, &first, &last)
+	if err != nil {
+		return info, err
+	}
+	info.First = first.Int64
+	info.Last = last.Int64
+	return info, nil
 }
 
-func (s *Store) Begin() error {
+func (s *SQLStore) Begin() error {
 	if s.tx != nil {
 		return errors.New("transaction already in progress")
 	}
@@ -133,7 +209,7 @@ func (s *Store) Begin() error {
 	return err
 }
 
-func (s *Store) Commit() error {
+func (s *SQLStore) Commit() error {
 	if s.tx == nil {
 		return errors.New("No transaction inprogress")
 	}
@@ -142,125 +218,492 @@ func (s *Store) Commit() error {
 	return err
 }
 
-func (s Store) query(query string, args ...interface{}) (*sql.Rows, error) {
+// rebind turns ? placeholders into the $1 form PostgreSQL expects
+func (s SQLStore) rebind(query string) string {
+	if s.driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (s SQLStore) query(query string, args ...interface{}) (*sql.Rows, error) {
+	query = s.rebind(query)
 	if s.tx == nil {
 		return s.db.Query(query, args...)
 	}
 	return s.tx.Query(query, args...)
 }
 
-func (s Store) exec(query string, args ...interface{}) (sql.Result, error) {
+func (s SQLStore) exec(query string, args ...interface{}) (sql.Result, error) {
+	query = s.rebind(query)
 	if s.tx == nil {
 		return s.db.Exec(query, args...)
 	}
 	return s.tx.Exec(query, args...)
 }
 
-func (s Store) GetJobs() ([]Job, error) {
-	rows, err := s.query("select name, url from jobs")
-	if err != nil {
-		return nil, err
-	}
+const jobColumns = "jobs.server, jobs.name, jobs.url, jobs.archived"
+
+func scanJobs(rows *sql.Rows) ([]Job, error) {
 	defer rows.Close()
 	var jobs []Job
 	for rows.Next() {
-		var name, url string
-		if err := rows.Scan(&name, &url); err != nil {
+		var job Job
+		if err := rows.Scan(&job.Server, &job.Name, &job.Url, &job.Archived); err != nil {
 			return nil, err
 		}
-		jobs = append(jobs, Job{name, url})
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+func (s SQLStore) GetJobs() ([]Job, error) {
+	rows, err := s.query("select " + jobColumns + " from jobs order by server, name")
+	if err != nil {
+		return nil, err
 	}
-	return jobs, nil
+	return scanJobs(rows)
 }
 
-func (s Store) GetJob(name string) (Job, error) {
-	rows, err := s.query("select name, url from jobs where name = ?", name)
+func (s SQLStore) GetJob(server, name string) (Job, error) {
+	rows, err := s.query("select "+jobColumns+" from jobs where server = ? and name = ?", server, name)
 	if err != nil {
 		return Job{}, err
 	}
+	jobs, err := scanJobs(rows)
+	if err != nil {
+		return Job{}, err
+	}
+	if len(jobs) == 0 {
+		return Job{}, errors.New("Job not found " + name + " on " + server)
+	}
+	return jobs[0], nil
+}
+
+func (s SQLStore) PutJob(job Job) error {
+	_, err := s.exec("insert into jobs(server, name, url, archived) values (?, ?, ?, ?)", job.Server, job.Name, job.Url, job.Archived)
+	return err
+}
+
+func (s SQLStore) ArchiveJob(job Job, archived int64) error {
+	_, err := s.exec("update jobs set archived = ? where server = ? and name = ?", archived, job.Server, job.Name)
+	return err
+}
+
+func (s SQLStore) GetRules() ([]Rule, error) {
+	rows, err := s.query("select server, pattern, exclude from rules order by server, exclude, pattern")
+	if err != nil {
+		return nil, err
+	}
 	defer rows.Close()
-	if rows.Next() {
-		var name, url string
-		if err := rows.Scan(&name, &url); err != nil {
-			return Job{}, err
+	var rules []Rule
+	for rows.Next() {
+		var rule Rule
+		var exclude int
+		if err := rows.Scan(&rule.Server, &rule.Pattern, &exclude); err != nil {
+			return nil, err
 		}
-		return Job{name, url}, nil
+		rule.Exclude = exclude != 0
+		rules = append(rules, rule)
 	}
-	return Job{}, errors.New("Job not found " + name)
+	return rules, rows.Err()
 }
 
-func (s Store) PutJob(job Job) error {
-	_, err := s.exec("insert into jobs values (?, ?)", job.Name, job.Url)
+func (s SQLStore) PutRule(rule Rule) error {
+	exclude := 0
+	if rule.Exclude {
+		exclude = 1
+	}
+	_, err := s.exec("insert into rules values (?, ?, ?)", rule.Server, rule.Pattern, exclude)
 	return err
 }
 
-func conv64(in sql.NullString) (int64, error) {
-	if !in.Valid {
-		return -1, nil
-	}
-	i, err := strconv.ParseInt(in.String, 10, 64)
+func (s SQLStore) DeleteRule(server, pattern string) error {
+	res, err := s.exec("delete from rules where server = ? and pattern = ?", server, pattern)
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("No rule " + pattern + " for " + server)
 	}
-	return i, nil
+	return nil
 }
 
-func conv(in sql.NullString) (int, error) {
-	if !in.Valid {
-		return -1, nil
+// orMissing keeps the old convention of -1 for values that were not recorded
+func orMissing(n sql.NullInt64) int64 {
+	if !n.Valid {
+		return -1
 	}
-	i, err := strconv.Atoi(in.String)
+	return n.Int64
+}
+
+func (s SQLStore) GetBuilds(server, name string) ([]Build, error) {
+	return s.QueryBuilds(BuildQuery{Server: server, Job: name})
+}
+
+func (s SQLStore) LastBuild(server, name string) (int, error) {
+	rows, err := s.query("select max(number) from builds where server = ? and job = ?", server, name)
 	if err != nil {
 		return 0, err
 	}
-	return i, nil
+	defer rows.Close()
+	var last sql.NullInt64
+	if rows.Next() {
+		if err := rows.Scan(&last); err != nil {
+			return 0, err
+		}
+	}
+	return int(last.Int64), rows.Err()
 }
 
-func (s Store) GetBuilds(name string) ([]Build, error) {
-	rows, err := s.query("select job, number, start, duration, host, result, failed, total from builds where job = ?", name)
+func (s SQLStore) QueryBuilds(q BuildQuery) ([]Build, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if q.Server != "" {
+		add("server = ?", q.Server)
+	}
+	if q.Job != "" {
+		add("job = ?", q.Job)
+	}
+	if q.Host != "" {
+		add("host = ?", q.Host)
+	}
+	if q.Result != "" {
+		add("result = ?", q.Result)
+	}
+	if q.From != 0 {
+		add("start >= ?", q.From)
+	}
+	if q.To != 0 {
+		add("start < ?", q.To)
+	}
+	if q.Running {
+		where = append(where, "coalesce(result, '') = ''")
+	}
+	query := "select server, job, number, start, duration, host, result, failed, total from builds"
+	if len(where) > 0 {
+		query = query + " where " + strings.Join(where, " and ")
+	}
+	rows, err := s.query(query+" order by server, job, number", args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var builds []Build
 	for rows.Next() {
-		var job, snumber, sstart, sduration, host, result, sfailed, stotal sql.NullString
-		if err := rows.Scan(&job, &snumber, &sstart, &sduration, &host, &result, &sfailed, &stotal); err != nil {
+		var b Build
+		var start, duration, failed, total sql.NullInt64
+		var host, result sql.NullString
+		if err := rows.Scan(&b.Server, &b.Job, &b.Number, &start, &duration, &host, &result, &failed, &total); err != nil {
 			return nil, err
 		}
-		number, err := conv(snumber)
-		if err != nil {
+		b.Start = orMissing(start)
+		b.Duration = orMissing(duration)
+		b.Host = host.String
+		b.Result = result.String
+		b.Failed = int(orMissing(failed))
+		b.Total = int(orMissing(total))
+		builds = append(builds, b)
+	}
+	return builds, rows.Err()
+}
+
+func (s SQLStore) InsertBuild(build Build) error {
+	_, err := s.exec("insert into builds values (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		build.Server, build.Job, build.Number, build.Start, build.Duration, build.Host, build.Result, build.Failed, build.Total)
+	return err
+}
+
+func (s SQLStore) UpdateBuild(build Build) error {
+	_, err := s.exec("update builds set start = ?, duration = ?, host = ?, result = ?, failed = ?, total = ? where server = ? and job = ? and number = ?",
+		build.Start, build.Duration, build.Host, build.Result, build.Failed, build.Total, build.Server, build.Job, build.Number)
+	return err
+}
+
+// metaTables hold the metadata of a build, PutMeta replaces it in all and
+// in build_stages when stages were fetched
+var metaTables = []string{"build_meta", "build_causes", "build_parameters", "build_changes", "build_culprits"}
+
+func (s SQLStore) deleteFrom(tables []string, server, job string, number int) error {
+	for _, table := range tables {
+		if _, err := s.exec("delete from "+table+" where server = ? and job = ? and number = ?", server, job, number); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s SQLStore) DeleteBuild(build Build) error {
+	return s.deleteFrom(append([]string{"builds", "failure_causes", "build_stages"}, metaTables...), build.Server, build.Job, build.Number)
+}
+
+// LogFailure records why the last refresh of a job failed, replacing any
+// earlier failure for it
+func (s SQLStore) LogFailure(job Job, message string) error {
+	if err := s.ClearFailure(job); err != nil {
+		return err
+	}
+	_, err := s.exec("insert into refresh_log values (?, ?, ?, ?)", job.Server, job.Name, time.Now().Unix(), message)
+	return err
+}
+
+func (s SQLStore) ClearFailure(job Job) error {
+	_, err := s.exec("delete from refresh_log where server = ? and job = ?", job.Server, job.Name)
+	return err
+}
+
+func (s SQLStore) FailedJobs() ([]Job, error) {
+	rows, err := s.query("select " + jobColumns + " from jobs join refresh_log " +
+		"on jobs.server = refresh_log.server and jobs.name = refresh_log.job order by jobs.server, jobs.name")
+	if err != nil {
+		return nil, err
+	}
+	return scanJobs(rows)
+}
+
+func (s SQLStore) GetRetention() ([]Retention, error) {
+	rows, err := s.query("select pattern, days, builds, failure_days from retention order by position")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []Retention
+	for rows.Next() {
+		var rule Retention
+		if err := rows.Scan(&rule.Pattern, &rule.Days, &rule.Builds, &rule.FailureDays); err != nil {
 			return nil, err
 		}
-		start, err := conv64(sstart)
-		if err != nil {
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// PutRetention replaces the rule for the same pattern in place or adds it
+// last
+func (s SQLStore) PutRetention(rule Retention) error {
+	res, err := s.exec("update retention set days = ?, builds = ?, failure_days = ? where pattern = ?",
+		rule.Days, rule.Builds, rule.FailureDays, rule.Pattern)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	rows, err := s.query("select coalesce(max(position), 0) from retention")
+	if err != nil {
+		return err
+	}
+	var last int
+	if rows.Next() {
+		err = rows.Scan(&last)
+	}
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	_, err = s.exec("insert into retention values (?, ?, ?, ?, ?)", rule.Pattern, last+1, rule.Days, rule.Builds, rule.FailureDays)
+	return err
+}
+
+func (s SQLStore) DeleteRetention(pattern string) error {
+	res, err := s.exec("delete from retention where pattern = ?", pattern)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return errors.New("No retention rule " + pattern)
+	}
+	return nil
+}
+
+func (s SQLStore) GetRollups() ([]Rollup, error) {
+	rows, err := s.query("select server, job, host, day, result, builds, duration from rollups order by server, job, day, host, result")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rollups []Rollup
+	for rows.Next() {
+		var r Rollup
+		if err := rows.Scan(&r.Server, &r.Job, &r.Host, &r.Day, &r.Result, &r.Builds, &r.Duration); err != nil {
 			return nil, err
 		}
-		duration, err := conv64(sduration)
-		if err != nil {
+		rollups = append(rollups, r)
+	}
+	return rollups, rows.Err()
+}
+
+// AddRollup adds the counts to the row for the same day, host and result
+func (s SQLStore) AddRollup(r Rollup) error {
+	res, err := s.exec("update rollups set builds = builds + ?, duration = duration + ? "+
+		"where server = ? and job = ? and host = ? and day = ? and result = ?",
+		r.Builds, r.Duration, r.Server, r.Job, r.Host, r.Day, r.Result)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return err
+	}
+	_, err = s.exec("insert into rollups values (?, ?, ?, ?, ?, ?, ?)", r.Server, r.Job, r.Host, r.Day, r.Result, r.Builds, r.Duration)
+	return err
+}
+
+func (s SQLStore) GetCauses() ([]FailureCause, error) {
+	rows, err := s.query("select server, job, number, category, cause, excerpt from failure_causes order by server, job, number")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var causes []FailureCause
+	for rows.Next() {
+		var c FailureCause
+		if err := rows.Scan(&c.Server, &c.Job, &c.Number, &c.Category, &c.Cause, &c.Excerpt); err != nil {
 			return nil, err
 		}
-		failed, err := conv(sfailed)
-		if err != nil {
+		causes = append(causes, c)
+	}
+	return causes, rows.Err()
+}
+
+// PutCause replaces the cause stored for the same build
+func (s SQLStore) PutCause(c FailureCause) error {
+	_, err := s.exec("delete from failure_causes where server = ? and job = ? and number = ?", c.Server, c.Job, c.Number)
+	if err != nil {
+		return err
+	}
+	_, err = s.exec("insert into failure_causes values (?, ?, ?, ?, ?, ?)", c.Server, c.Job, c.Number, c.Category, c.Cause, c.Excerpt)
+	return err
+}
+
+func (s SQLStore) GetMetas(server, name string) ([]BuildMeta, error) {
+	rows, err := s.query("select number, built_on, upstream_job, upstream_number from build_meta where server = ? and job = ? order by number", server, name)
+	if err != nil {
+		return nil, err
+	}
+	var metas []BuildMeta
+	index := make(map[int]int)
+	for rows.Next() {
+		meta := BuildMeta{Server: server, Job: name, Parameters: make(map[string]string)}
+		if err := rows.Scan(&meta.Number, &meta.BuiltOn, &meta.UpstreamJob, &meta.UpstreamNumber); err != nil {
+			rows.Close()
 			return nil, err
 		}
-		total, err := conv(stotal)
+		index[meta.Number] = len(metas)
+		metas = append(metas, meta)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// the other tables have any number of rows per build, scanned into
+	// values and then added by add
+	load := func(query string, values []interface{}, add func(meta *BuildMeta)) error {
+		rows, err := s.query(query, server, name)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		builds = append(builds, Build{job.String, number, start, duration, host.String, result.String, failed, total})
+		defer rows.Close()
+		var number int
+		for rows.Next() {
+			if err := rows.Scan(append([]interface{}{&number}, values...)...); err != nil {
+				return err
+			}
+			if i, ok := index[number]; ok {
+				add(&metas[i])
+			}
+		}
+		return rows.Err()
+	}
+	var cause BuildCause
+	var change Change
+	var stage Stage
+	var param, value, culprit string
+	err = load("select number, kind, description, user_id from build_causes where server = ? and job = ? order by number, position",
+		[]interface{}{&cause.Kind, &cause.Description, &cause.User}, func(meta *BuildMeta) { meta.Causes = append(meta.Causes, cause) })
+	if err == nil {
+		err = load("select number, name, value from build_parameters where server = ? and job = ?",
+			[]interface{}{&param, &value}, func(meta *BuildMeta) { meta.Parameters[param] = value })
 	}
-	return builds, nil
+	if err == nil {
+		err = load("select number, commit_id, author, message from build_changes where server = ? and job = ? order by number, position",
+			[]interface{}{&change.Commit, &change.Author, &change.Message}, func(meta *BuildMeta) { meta.Changes = append(meta.Changes, change) })
+	}
+	if err == nil {
+		err = load("select number, name from build_culprits where server = ? and job = ? order by number, position",
+			[]interface{}{&culprit}, func(meta *BuildMeta) { meta.Culprits = append(meta.Culprits, culprit) })
+	}
+	if err == nil {
+		err = load("select number, name, status, host, start, duration from build_stages where server = ? and job = ? order by number, position",
+			[]interface{}{&stage.Name, &stage.Status, &stage.Host, &stage.Start, &stage.Duration}, func(meta *BuildMeta) { meta.Stages = append(meta.Stages, stage) })
+	}
+	if err != nil {
+		return nil, err
+	}
+	return metas, nil
 }
 
-func (s Store) InsertBuild(build Build) error {
-	_, err := s.exec("insert into builds values (?, ?, ?, ?, ?, ?, ?, ?)",
-		build.Job, build.Number, build.Start, build.Duration, build.Host, build.Result, build.Failed, build.Total)
-	return err
+// PutMeta replaces the metadata stored for the same build
+func (s SQLStore) PutMeta(meta BuildMeta) error {
+	tables := metaTables
+	if meta.Stages != nil {
+		tables = append([]string{"build_stages"}, metaTables...)
+	}
+	if err := s.deleteFrom(tables, meta.Server, meta.Job, meta.Number); err != nil {
+		return err
+	}
+	_, err := s.exec("insert into build_meta values (?, ?, ?, ?, ?, ?)", meta.Server, meta.Job, meta.Number, meta.BuiltOn, meta.UpstreamJob, meta.UpstreamNumber)
+	if err != nil {
+		return err
+	}
+	for i, c := range meta.Causes {
+		if _, err := s.exec("insert into build_causes values (?, ?, ?, ?, ?, ?, ?)", meta.Server, meta.Job, meta.Number, i, c.Kind, c.Description, c.User); err != nil {
+			return err
+		}
+	}
+	for name, value := range meta.Parameters {
+		if _, err := s.exec("insert into build_parameters values (?, ?, ?, ?, ?)", meta.Server, meta.Job, meta.Number, name, value); err != nil {
+			return err
+		}
+	}
+	for i, c := range meta.Changes {
+		if _, err := s.exec("insert into build_changes values (?, ?, ?, ?, ?, ?, ?)", meta.Server, meta.Job, meta.Number, i, c.Commit, c.Author, c.Message); err != nil {
+			return err
+		}
+	}
+	for i, name := range meta.Culprits {
+		if _, err := s.exec("insert into build_culprits values (?, ?, ?, ?, ?)", meta.Server, meta.Job, meta.Number, i, name); err != nil {
+			return err
+		}
+	}
+	for i, st := range meta.Stages {
+		if _, err := s.exec("insert into build_stages values (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			meta.Server, meta.Job, meta.Number, i, st.Name, st.Status, st.Host, st.Start, st.Duration); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (s Store) UpdateBuild(build Build) error {
-	_, err := s.exec("update builds set start = ?, duration = ?, host = ?, result = ?, failed = ?, total = ? where job = ? and number = ?",
-		build.Start, build.Duration, build.Host, build.Result, build.Failed, build.Total, build.Job, build.Number)
+// Vacuum gives the space of deleted rows back, it can not run inside a
+// transaction
+func (s SQLStore) Vacuum() error {
+	if s.tx != nil {
+		return errors.New("Can not vacuum in a transaction")
+	}
+	_, err := s.db.Exec("vacuum")
 	return err
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Trend struct {
+	Server   string
+	Job      string
+	Builds   int
+	Baseline int64
+	Current  int64
+	// Step is the build where durations stepped up by more than the
+	// threshold and stayed there, 0 when there is no such build
+	Step      int
+	StepStart int64
+	StepRatio float64
+	Sparkline string
+}
+
+func (t Trend) Regression() bool {
+	return t.Step > 0
+}
+
+func (t Trend) Change() float64 {
+	if t.Baseline == 0 {
+		return 0
+	}
+	return float64(t.Current)/float64(t.Baseline) - 1
+}
+
+func median(values []int64) int64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a] < sorted[b] })
+	return percentile(sorted, 0.5)
+}
+
+const sparks = "_.-=+*#%@"
+
+// sparkline scales the values between their min and max
+func sparkline(values []int64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	var b strings.Builder
+	for _, v := range values {
+		level := 0
+		if max > min {
+			level = int((v - min) * int64(len(sparks)-1) / (max - min))
+		}
+		b.WriteByte(sparks[level])
+	}
+	return b.String()
+}
+
+// JobTrend compares each window of builds with the window before it. The
+// biggest step up that is above threshold and still holds for the latest
+// window is reported as a regression.
+func JobTrend(builds []Build, window int, threshold float64, width int) Trend {
+	var finished []Build
+	for _, b := range builds {
+		if b.Result != "" && b.Duration > 0 {
+			finished = append(finished, b)
+		}
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].Number < finished[b].Number })
+	durations := make([]int64, len(finished))
+	for i, b := range finished {
+		durations[i] = b.Duration
+	}
+	trend := Trend{Builds: len(finished)}
+	if len(finished) > 0 {
+		trend.Server = finished[0].Server
+		trend.Job = finished[0].Job
+	}
+	recent := durations
+	if len(recent) > width {
+		recent = recent[len(recent)-width:]
+	}
+	trend.Sparkline = sparkline(recent)
+	if len(durations) < window {
+		trend.Current = median(durations)
+		trend.Baseline = trend.Current
+		return trend
+	}
+	trend.Current = median(durations[len(durations)-window:])
+	trend.Baseline = median(durations[0 : len(durations)-window])
+	if trend.Baseline == 0 {
+		trend.Baseline = trend.Current
+	}
+	for i := window; i+window <= len(durations); i++ {
+		before := median(durations[i-window : i])
+		after := median(durations[i : i+window])
+		if before == 0 {
+			continue
+		}
+		ratio := float64(after) / float64(before)
+		if ratio >= 1+threshold && ratio > trend.StepRatio && float64(trend.Current) >= (1+threshold)*float64(before) {
+			trend.Step = finished[i].Number
+			trend.StepStart = finished[i].Start
+			trend.StepRatio = ratio
+			trend.Baseline = before
+		}
+	}
+	return trend
+}
+
+func trendTable(trends []Trend) Table {
+	t := Table{Headers: []string{"Job", "Builds", "Baseline", "Current", "Change", "Regression", "Since", "Trend"}}
+	for _, trend := range trends {
+		regression := ""
+		since := ""
+		if trend.Regression() {
+			regression = "#" + strconv.Itoa(trend.Step)
+			since = time.Unix(trend.StepStart/1000, 0).Format("2006-01-02 15:04")
+		}
+		t.Add(trend.Job, trend.Builds, msDuration(trend.Baseline), msDuration(trend.Current),
+			strconv.FormatFloat(100*trend.Change(), 'f', 0, 64)+"%", regression, since, trend.Sparkline)
+	}
+	return t
+}
+
+// Trends groups builds per job and sorts regressions first
+func Trends(builds []Build, window int, threshold float64) []Trend {
+	jobs := make(map[string][]Build)
+	var keys []string
+	for _, b := range builds {
+		key := b.Server + " " + b.Job
+		if _, ok := jobs[key]; !ok {
+			keys = append(keys, key)
+		}
+		jobs[key] = append(jobs[key], b)
+	}
+	var trends []Trend
+	for _, key := range keys {
+		trend := JobTrend(jobs[key], window, threshold, 40)
+		if trend.Builds > 0 {
+			trends = append(trends, trend)
+		}
+	}
+	sort.SliceStable(trends, func(a, b int) bool {
+		if trends[a].Regression() != trends[b].Regression() {
+			return trends[a].Regression()
+		}
+		return trends[a].Change() > trends[b].Change()
+	})
+	return trends
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+func durationBuilds(durations ...int64) []Build {
+	var builds []Build
+	for i, d := range durations {
+		builds = append(builds, Build{"http://fake/jenkins", "job", i + 1, int64(i+1) * 1000, d, "host", "SUCCESS", -1, -1})
+	}
+	return builds
+}
+
+func TestJobTrendStep(t *testing.T) {
+	builds := durationBuilds(100, 105, 95, 100, 98, 102, 140, 135, 150, 138, 142, 139)
+	trend := JobTrend(builds, 4, 0.3, 40)
+	if !trend.Regression() || trend.Step != 7 || trend.StepStart != 7000 {
+		t.Fatalf("Expected regression from build 7 but got %+v", trend)
+	}
+	if trend.Baseline != 98 || trend.Current != 139 {
+		t.Fatalf("Unexpected baseline/current %+v", trend)
+	}
+	if len(trend.Sparkline) != 12 || trend.Sparkline[0] != '_' || trend.Sparkline[8] != '@' {
+		t.Fatalf("Unexpected sparkline %s", trend.Sparkline)
+	}
+}
+
+func TestJobTrendNoiseAndRecovery(t *testing.T) {
+	if trend := JobTrend(durationBuilds(100, 130, 90, 110, 100, 120, 95, 105, 100, 115), 4, 0.3, 40); trend.Regression() {
+		t.Fatalf("Did not expect regression in noise %+v", trend)
+	}
+	// slower for a while but back to normal now
+	if trend := JobTrend(durationBuilds(100, 100, 100, 100, 200, 200, 200, 200, 100, 100, 100, 100), 4, 0.3, 40); trend.Regression() {
+		t.Fatalf("Did not expect regression after recovery %+v", trend)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	if s := sparkline([]int64{1, 5, 9}); s != "_+@" {
+		t.Fatalf("Unexpected sparkline %s", s)
+	}
+	if s := sparkline([]int64{3, 3}); s != "__" {
+		t.Fatalf("Unexpected flat sparkline %s", s)
+	}
+}
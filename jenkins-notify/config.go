@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+type Rule struct {
+	Name        string
+	Events      []string
+	Job         string
+	Node        string
+	Result      []string
+	MinDuration Duration
+	Outputs     []string
+	job         *regexp.Regexp
+	node        *regexp.Regexp
+}
+
+type OutputConfig struct {
+	Type     string
+	Url      string
+	Template string
+	Command  string
+	Server   string
+	User     string
+	Password string
+	From     string
+	To       []string
+	Subject  string
+}
+
+type RateLimit struct {
+	Max int
+	Per Duration
+}
+
+type Config struct {
+	Interval  Duration
+	State     string
+	RateLimit RateLimit
+	Rules     []Rule
+	Outputs   map[string]OutputConfig
+}
+
+func LoadConfig(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+	var cfg Config
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return Config{}, errors.New("Could not parse " + path + ": " + err.Error())
+	}
+	if cfg.Interval.Duration == 0 {
+		cfg.Interval.Duration = 30 * time.Second
+	}
+	if cfg.RateLimit.Per.Duration == 0 {
+		cfg.RateLimit.Per.Duration = time.Minute
+	}
+	if cfg.State == "" {
+		cfg.State = os.Getenv("HOME") + "/.jenkins-notify.state"
+	}
+	for i := range cfg.Rules {
+		rule := &cfg.Rules[i]
+		if len(rule.Events) == 0 {
+			rule.Events = []string{"finished"}
+		}
+		if rule.job, err = regexp.Compile(rule.Job); err != nil {
+			return Config{}, errors.New("Invalid job pattern in rule " + rule.Name + ": " + err.Error())
+		}
+		if rule.node, err = regexp.Compile(rule.Node); err != nil {
+			return Config{}, errors.New("Invalid node pattern in rule " + rule.Name + ": " + err.Error())
+		}
+		for _, output := range rule.Outputs {
+			if _, ok := cfg.Outputs[output]; !ok {
+				return Config{}, errors.New("Unknown output " + output + " in rule " + rule.Name)
+			}
+		}
+	}
+	for name, output := range cfg.Outputs {
+		if output.Type != "webhook" {
+			continue
+		}
+		if u, err := url.Parse(output.Url); err != nil || u.Scheme == "" || u.Host == "" {
+			return Config{}, errors.New("Invalid url '" + output.Url + "' for output " + name)
+		}
+	}
+	return cfg, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsResult is true for rules that can only match a finished build once
+// its result is known
+func (r Rule) NeedsResult() bool {
+	return len(r.Result) > 0 || r.MinDuration.Duration > 0
+}
+
+// MatchEvent checks the event, job and node of a rule but not the result
+func (r Rule) MatchEvent(n Notification) bool {
+	if !contains(r.Events, n.Type) {
+		return false
+	}
+	if r.Job != "" && (n.Build == "" || !r.job.MatchString(n.Build)) {
+		return false
+	}
+	return r.node.MatchString(n.Node)
+}
+
+func (r Rule) Match(n Notification) bool {
+	if !r.MatchEvent(n) {
+		return false
+	}
+	if len(r.Result) > 0 && !contains(r.Result, n.Result) {
+		return false
+	}
+	if r.MinDuration.Duration > 0 && n.Duration < r.MinDuration.Duration {
+		return false
+	}
+	return true
+}
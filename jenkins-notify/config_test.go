@@ -0,0 +1,87 @@
+package main
+
+import (
+	"github.com/jwiklund/jenkins"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func rule(events []string, job, node string, results []string, min time.Duration) Rule {
+	return Rule{Events: events, Job: job, Node: node, Result: results, MinDuration: Duration{min},
+		job: regexp.MustCompile(job), node: regexp.MustCompile(node)}
+}
+
+func finished(build, node, result string, duration time.Duration) Notification {
+	return Notification{ExecutorEvent: jenkins.ExecutorEvent{Type: jenkins.BuildFinished, Node: node, Build: build, Number: 3},
+		Result: result, Duration: duration}
+}
+
+func TestRuleMatch(t *testing.T) {
+	offline := Notification{ExecutorEvent: jenkins.ExecutorEvent{Type: jenkins.NodeOffline, Node: "linux-1"}}
+	tests := []struct {
+		name     string
+		rule     Rule
+		n        Notification
+		expected bool
+	}{
+		{"any finished", rule([]string{"finished"}, "", "", nil, 0), finished("job", "a", "", 0), true},
+		{"wrong event", rule([]string{"started"}, "", "", nil, 0), finished("job", "a", "", 0), false},
+		{"event case", rule([]string{"FINISHED"}, "", "", nil, 0), finished("job", "a", "", 0), true},
+		{"job pattern", rule([]string{"finished"}, "^release-", "", nil, 0), finished("release-2", "a", "", 0), true},
+		{"job mismatch", rule([]string{"finished"}, "^release-", "", nil, 0), finished("nightly", "a", "", 0), false},
+		{"job on node event", rule([]string{"offline"}, "release", "", nil, 0), offline, false},
+		{"node pattern", rule([]string{"offline"}, "", "^linux-", nil, 0), offline, true},
+		{"result", rule([]string{"finished"}, "", "", []string{"FAILURE", "UNSTABLE"}, 0), finished("job", "a", "unstable", 0), true},
+		{"result mismatch", rule([]string{"finished"}, "", "", []string{"FAILURE"}, 0), finished("job", "a", "SUCCESS", 0), false},
+		{"result unknown", rule([]string{"finished"}, "", "", []string{"FAILURE"}, 0), finished("job", "a", "", 0), false},
+		{"long enough", rule([]string{"finished"}, "", "", nil, time.Hour), finished("job", "a", "SUCCESS", 2*time.Hour), true},
+		{"too short", rule([]string{"finished"}, "", "", nil, time.Hour), finished("job", "a", "SUCCESS", time.Minute), false},
+	}
+	for _, test := range tests {
+		if test.rule.Match(test.n) != test.expected {
+			t.Fatalf("%s: expected %v for %+v", test.name, test.expected, test.n)
+		}
+	}
+}
+
+func TestNeedsResult(t *testing.T) {
+	if rule([]string{"finished"}, "", "", nil, 0).NeedsResult() {
+		t.Fatal("Did not expect a rule without result or duration to need the result")
+	}
+	if !rule([]string{"finished"}, "", "", []string{"FAILURE"}, 0).NeedsResult() || !rule([]string{"finished"}, "", "", nil, time.Minute).NeedsResult() {
+		t.Fatal("Expected rules on result and duration to need the result")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.json")
+	os.WriteFile(path, []byte(`{"Rules": [{"Name": "failures", "Job": "^release", "Result": ["FAILURE"], "Outputs": ["chat"]}],
+		"Outputs": {"chat": {"Type": "webhook", "Url": "http://localhost/hook"}}}`), 0600)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if cfg.Interval.Duration != 30*time.Second || cfg.RateLimit.Per.Duration != time.Minute {
+		t.Fatalf("Expected default interval and rate limit window but got %+v", cfg)
+	}
+	if len(cfg.Rules) != 1 || cfg.Rules[0].Events[0] != "finished" || !cfg.Rules[0].Match(finished("release-1", "a", "FAILURE", 0)) {
+		t.Fatalf("Unexpected rules %+v", cfg.Rules)
+	}
+	os.WriteFile(path, []byte(`{"Rules": [{"Name": "r", "Outputs": ["missing"]}]}`), 0600)
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatal("Expected an unknown output to fail")
+	}
+	os.WriteFile(path, []byte(`{"Rules": [{"Name": "r", "Job": "("}]}`), 0600)
+	if _, err := LoadConfig(path); err == nil {
+		t.Fatal("Expected an invalid job pattern to fail")
+	}
+	for _, hook := range []string{"", "localhost/hook", "http://", "http://local host/"} {
+		os.WriteFile(path, []byte(`{"Outputs": {"chat": {"Type": "webhook", "Url": "`+hook+`"}}}`), 0600)
+		if _, err := LoadConfig(path); err == nil {
+			t.Fatalf("Expected the webhook url '%s' to fail", hook)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/jwiklund/jenkins"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+type Notification struct {
+	jenkins.ExecutorEvent
+	Result   string        `json:"result,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Rule     string        `json:"rule"`
+	Text     string        `json:"text"`
+}
+
+// Key identifies an event for deduplication. Node events are keyed on the
+// state alone so a restart does not announce a node that is still offline,
+// mark forgets the opposite state so the next change is announced again.
+func (n Notification) Key() string {
+	if n.Type == jenkins.NodeOffline || n.Type == jenkins.NodeOnline {
+		return nodeKey(n.Type, n.Node)
+	}
+	if n.Number == 0 {
+		return n.Type + " " + n.Node + " " + n.Build + " " + n.Time.Format(time.RFC3339)
+	}
+	return n.Type + " " + n.Node + " " + n.Build + " " + strconv.Itoa(n.Number)
+}
+
+func nodeKey(state, node string) string {
+	return state + " " + node
+}
+
+func describe(n Notification) string {
+	switch n.Type {
+	case jenkins.NodeOffline:
+		return "Node " + n.Node + " went offline"
+	case jenkins.NodeOnline:
+		return "Node " + n.Node + " is back online"
+	case jenkins.BuildStarted:
+		return n.Build + " #" + strconv.Itoa(n.Number) + " started on " + n.Node
+	}
+	if n.Result == "" {
+		return n.Build + " #" + strconv.Itoa(n.Number) + " finished on " + n.Node
+	}
+	return n.Build + " #" + strconv.Itoa(n.Number) + " " + n.Result + " on " + n.Node + " after " + n.Duration.String()
+}
+
+// complete looks up result and duration for a finished build, the executor
+// list only tells us that the build left the node. The build is found by
+// its url since the display name of a job in a folder is not its path. It
+// gives up after a minute so a build stuck in post processing is announced
+// without result.
+func complete(ctx context.Context, j jenkins.Jenkins, n Notification) Notification {
+	wctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	ref, err := jenkins.ParseBuildRef(n.Url)
+	var info jenkins.BuildInfo
+	if err == nil {
+		info, err = j.WaitForBuildEvery(wctx, ref, time.Second)
+	}
+	if err != nil {
+		fmt.Println("Could not get result of " + n.Build + ": " + err.Error())
+	} else {
+		n.Result = info.Result
+		n.Duration = time.Duration(info.Duration) * time.Millisecond
+	}
+	n.Text = describe(n)
+	return n
+}
+
+type notifier struct {
+	rules   []Rule
+	outputs map[string]Output
+	limits  map[string]*limiter
+	state   *State
+	dry     bool
+}
+
+// dispatch sends n to the outputs of the rules that match it, either the
+// rules that need the result of a build or the ones that do not. An output
+// gets n once, named after the first rule that sent it there.
+func (nt *notifier) dispatch(n Notification, withResult bool) {
+	sent := make(map[string]bool)
+	for _, rule := range nt.rules {
+		if rule.NeedsResult() != withResult || !rule.Match(n) {
+			continue
+		}
+		n.Rule = rule.Name
+		for _, name := range rule.Outputs {
+			if sent[name] {
+				continue
+			}
+			sent[name] = true
+			if nt.dry {
+				fmt.Println(name + ": " + n.Text)
+				continue
+			}
+			if !nt.limits[name].Allow(time.Now()) {
+				fmt.Println("Rate limited " + name + ": " + n.Text)
+				continue
+			}
+			if suppressed := nt.limits[name].Suppressed(); suppressed > 0 {
+				fmt.Println("Sending to " + name + " again after suppressing " + strconv.Itoa(suppressed) + " notifications")
+			}
+			if err := nt.outputs[name].Send(n); err != nil {
+				fmt.Println("Could not notify " + name + ": " + err.Error())
+			}
+		}
+	}
+}
+
+// needsResult tells if a rule that needs the result of the build could
+// match the finished build in n
+func (nt *notifier) needsResult(n Notification) bool {
+	if n.Type != jenkins.BuildFinished || n.Number == 0 {
+		return false
+	}
+	for _, rule := range nt.rules {
+		if rule.NeedsResult() && rule.MatchEvent(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// mark remembers that n was announced, a dry run leaves the state alone so
+// the real run still sends what it printed
+func (nt *notifier) mark(n Notification) {
+	if nt.dry {
+		return
+	}
+	nt.state.Mark(n.Key(), n.Time)
+	switch n.Type {
+	case jenkins.NodeOffline:
+		nt.state.Forget(nodeKey(jenkins.NodeOnline, n.Node))
+	case jenkins.NodeOnline:
+		nt.state.Forget(nodeKey(jenkins.NodeOffline, n.Node))
+	}
+	if err := nt.state.Save(); err != nil {
+		fmt.Println("Could not save state: " + err.Error())
+	}
+}
+
+// run dispatches events as they come. Results are looked up off the loop
+// so a slow build does not hold back other notifications, and the event is
+// only marked as announced once its result has been dispatched too.
+func (nt *notifier) run(ctx context.Context, j jenkins.Jenkins, events <-chan jenkins.ExecutorEvent) {
+	results := make(chan Notification)
+	pending := make(map[string]bool)
+	for events != nil || len(pending) > 0 {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			if event.Type == jenkins.WatchError {
+				fmt.Println("Could not poll executors: " + event.Error)
+				continue
+			}
+			n := Notification{ExecutorEvent: event}
+			n.Text = describe(n)
+			if nt.state.Announced(n.Key()) || pending[n.Key()] {
+				continue
+			}
+			nt.dispatch(n, false)
+			if !nt.needsResult(n) {
+				nt.mark(n)
+				continue
+			}
+			pending[n.Key()] = true
+			go func() {
+				results <- complete(ctx, j, n)
+			}()
+		case n := <-results:
+			delete(pending, n.Key())
+			nt.dispatch(n, true)
+			nt.mark(n)
+		}
+	}
+}
+
+func main() {
+	configPath := flag.String("config", os.Getenv("HOME")+"/.jenkins-notify.json", "Rules and outputs")
+	dry := flag.Bool("dry-run", false, "Print matches instead of sending them")
+	flag.Parse()
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		fmt.Println("Could not load config: " + err.Error())
+		os.Exit(1)
+	}
+	nt := &notifier{rules: cfg.Rules, outputs: make(map[string]Output), limits: make(map[string]*limiter), dry: *dry}
+	for name, oc := range cfg.Outputs {
+		if nt.outputs[name], err = NewOutput(name, oc); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		nt.limits[name] = &limiter{max: cfg.RateLimit.Max, per: cfg.RateLimit.Per.Duration}
+	}
+	if nt.state, err = LoadState(cfg.State); err != nil {
+		fmt.Println("Could not load state " + cfg.State + ": " + err.Error())
+		os.Exit(1)
+	}
+	j, err := jenkins.NewFromConfig()
+	if err != nil {
+		fmt.Println("Could not configure jenkins: " + err.Error())
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	nt.run(ctx, j, j.Watch(ctx, cfg.Interval.Duration))
+}
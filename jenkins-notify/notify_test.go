@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/jwiklund/jenkins"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationKey(t *testing.T) {
+	at := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	build := jenkins.ExecutorEvent{Type: jenkins.BuildFinished, Node: "a", Build: "job", Number: 3, Time: at}
+	later := build
+	later.Time = at.Add(time.Minute)
+	offline := jenkins.ExecutorEvent{Type: jenkins.NodeOffline, Node: "a", Time: at}
+	again := offline
+	again.Time = at.Add(time.Hour)
+	tests := []struct {
+		a, b jenkins.ExecutorEvent
+		same bool
+	}{
+		{build, later, true},
+		{build, jenkins.ExecutorEvent{Type: jenkins.BuildStarted, Node: "a", Build: "job", Number: 3, Time: at}, false},
+		{build, jenkins.ExecutorEvent{Type: jenkins.BuildFinished, Node: "a", Build: "job", Number: 4, Time: at}, false},
+		{offline, offline, true},
+		{offline, again, true},
+		{offline, jenkins.ExecutorEvent{Type: jenkins.NodeOnline, Node: "a", Time: at}, false},
+		{offline, jenkins.ExecutorEvent{Type: jenkins.NodeOffline, Node: "b", Time: at}, false},
+	}
+	for _, test := range tests {
+		a, b := Notification{ExecutorEvent: test.a}, Notification{ExecutorEvent: test.b}
+		if (a.Key() == b.Key()) != test.same {
+			t.Fatalf("Expected same=%v for %s and %s", test.same, a.Key(), b.Key())
+		}
+	}
+}
+
+type recorder struct {
+	sent []Notification
+}
+
+func (r *recorder) Send(n Notification) error {
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+// slowJenkins answers results after a delay, only for builds of job and
+// folder/job
+type slowJenkins struct {
+	jenkins.Jenkins
+	delay time.Duration
+}
+
+func (s slowJenkins) WaitForBuildEvery(ctx context.Context, ref jenkins.BuildRef, interval time.Duration) (jenkins.BuildInfo, error) {
+	if ref.Job != "job" && ref.Job != "folder/job" {
+		return jenkins.BuildInfo{}, errors.New("no build " + ref.String())
+	}
+	time.Sleep(s.delay)
+	return jenkins.BuildInfo{Number: ref.Number, Result: "FAILURE", Duration: 1000}, nil
+}
+
+func newNotifier(t *testing.T, dry bool, rules ...Rule) (*notifier, *recorder) {
+	state, err := LoadState(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	out := &recorder{}
+	for i := range rules {
+		rules[i].Outputs = []string{"out"}
+	}
+	return &notifier{rules: rules, outputs: map[string]Output{"out": out}, limits: map[string]*limiter{"out": {}}, state: state, dry: dry}, out
+}
+
+func TestNotifierRun(t *testing.T) {
+	failures := rule([]string{"finished"}, "", "", []string{"FAILURE"}, 0)
+	failures.Name = "failures"
+	offline := rule([]string{"offline"}, "", "", nil, 0)
+	offline.Name = "offline"
+	nt, out := newNotifier(t, false, failures, offline)
+	now := time.Now()
+	events := make(chan jenkins.ExecutorEvent, 4)
+	build := jenkins.ExecutorEvent{Type: jenkins.BuildFinished, Node: "a", Build: "job", Number: 3, Url: "http://x/job/job/3/", Time: now}
+	events <- build
+	events <- jenkins.ExecutorEvent{Type: jenkins.NodeOffline, Node: "b", Time: now}
+	// repeated while the result is still being looked up
+	events <- build
+	close(events)
+	nt.run(context.Background(), slowJenkins{delay: 50 * time.Millisecond}, events)
+	if len(out.sent) != 2 {
+		t.Fatalf("Expected two notifications but got %+v", out.sent)
+	}
+	if out.sent[0].Rule != "offline" || out.sent[1].Rule != "failures" || out.sent[1].Result != "FAILURE" {
+		t.Fatalf("Expected the offline node before the slow result but got %+v", out.sent)
+	}
+	if !nt.state.Announced(out.sent[1].Key()) || !nt.state.Announced(out.sent[0].Key()) {
+		t.Fatal("Expected both events to be marked as announced")
+	}
+}
+
+func TestNotifierSkipsUnneededResults(t *testing.T) {
+	finishedRule := rule([]string{"finished"}, "", "", nil, 0)
+	nt, out := newNotifier(t, false, finishedRule)
+	events := make(chan jenkins.ExecutorEvent, 1)
+	// the fake fails for any other job, so a lookup would show up as no result
+	events <- jenkins.ExecutorEvent{Type: jenkins.BuildFinished, Node: "a", Build: "other", Number: 1, Url: "http://x/job/other/1/"}
+	close(events)
+	nt.run(context.Background(), slowJenkins{}, events)
+	if len(out.sent) != 1 || out.sent[0].Text != "other #1 finished on a" {
+		t.Fatalf("Unexpected notifications %+v", out.sent)
+	}
+}
+
+func TestNotifierDryRun(t *testing.T) {
+	nt, out := newNotifier(t, true, rule([]string{"offline"}, "", "", nil, 0))
+	events := make(chan jenkins.ExecutorEvent, 1)
+	events <- jenkins.ExecutorEvent{Type: jenkins.NodeOffline, Node: "b"}
+	close(events)
+	nt.run(context.Background(), slowJenkins{}, events)
+	if len(out.sent) != 0 || len(nt.state.Seen) != 0 {
+		t.Fatalf("Expected a dry run to neither send nor mark but got %+v and %v", out.sent, nt.state.Seen)
+	}
+}
+
+func TestNotifierFolderJob(t *testing.T) {
+	nt, out := newNotifier(t, false, rule([]string{"finished"}, "", "", []string{"FAILURE"}, 0))
+	events := make(chan jenkins.ExecutorEvent, 1)
+	events <- jenkins.ExecutorEvent{Type: jenkins.BuildFinished, Node: "a", Build: "folder » job", Number: 5, Url: "http://x/job/folder/job/job/5/"}
+	close(events)
+	nt.run(context.Background(), slowJenkins{}, events)
+	if len(out.sent) != 1 || out.sent[0].Result != "FAILURE" {
+		t.Fatalf("Expected the result of the folder job but got %+v", out.sent)
+	}
+}
+
+func TestNotifierOncePerOutput(t *testing.T) {
+	first := rule([]string{"offline"}, "", "", nil, 0)
+	first.Name = "first"
+	second := rule([]string{"offline", "online"}, "", "", nil, 0)
+	second.Name = "second"
+	nt, out := newNotifier(t, false, first, second)
+	now := time.Now()
+	events := make(chan jenkins.ExecutorEvent, 4)
+	events <- jenkins.ExecutorEvent{Type: jenkins.NodeOffline, Node: "b", Time: now}
+	events <- jenkins.ExecutorEvent{Type: jenkins.NodeOnline, Node: "b", Time: now}
+	events <- jenkins.ExecutorEvent{Type: jenkins.NodeOffline, Node: "b", Time: now}
+	// still offline, as after a restart
+	events <- jenkins.ExecutorEvent{Type: jenkins.NodeOffline, Node: "b", Time: now}
+	close(events)
+	nt.run(context.Background(), slowJenkins{}, events)
+	var rules []string
+	for _, n := range out.sent {
+		rules = append(rules, n.Type+" "+n.Rule)
+	}
+	if strings.Join(rules, ", ") != "offline first, online second, offline first" {
+		t.Fatalf("Expected each change once per output but got %v", rules)
+	}
+}
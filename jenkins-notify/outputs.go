@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/smtp"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type Output interface {
+	Send(n Notification) error
+}
+
+const defaultTemplate = `{"text": {{json .Text}}}`
+
+var funcs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+type webhook struct {
+	url  string
+	body *template.Template
+}
+
+type command struct {
+	command string
+}
+
+type mail struct {
+	cfg OutputConfig
+}
+
+func NewOutput(name string, cfg OutputConfig) (Output, error) {
+	switch cfg.Type {
+	case "webhook":
+		text := cfg.Template
+		if text == "" {
+			text = defaultTemplate
+		}
+		body, err := template.New(name).Funcs(funcs).Parse(text)
+		if err != nil {
+			return nil, errors.New("Invalid template for " + name + ": " + err.Error())
+		}
+		return webhook{cfg.Url, body}, nil
+	case "command":
+		return command{cfg.Command}, nil
+	case "smtp":
+		if cfg.Server == "" || len(cfg.To) == 0 {
+			return nil, errors.New("Output " + name + " needs server and to")
+		}
+		return mail{cfg}, nil
+	}
+	return nil, errors.New("Unknown output type '" + cfg.Type + "' for " + name)
+}
+
+func (w webhook) Send(n Notification) error {
+	var body bytes.Buffer
+	if err := w.body.Execute(&body, n); err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(w.url, "application/json", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New("Webhook returned " + resp.Status)
+	}
+	return nil
+}
+
+// Send runs the command with the notification as json on stdin
+func (c command) Send(n Notification) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", c.command)
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New(err.Error() + ": " + strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (m mail) Send(n Notification) error {
+	subject := m.cfg.Subject
+	if subject == "" {
+		subject = "[jenkins] " + n.Text
+	}
+	msg := "From: " + m.cfg.From + "\r\n" +
+		"To: " + strings.Join(m.cfg.To, ", ") + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"\r\n" + n.Text + "\r\n"
+	if n.Url != "" {
+		msg = msg + n.Url + "\r\n"
+	}
+	var auth smtp.Auth
+	if m.cfg.User != "" {
+		host := m.cfg.Server
+		if ind := strings.Index(host, ":"); ind != -1 {
+			host = host[0:ind]
+		}
+		auth = smtp.PlainAuth("", m.cfg.User, m.cfg.Password, host)
+	}
+	return smtp.SendMail(m.cfg.Server, auth, m.cfg.From, m.cfg.To, []byte(msg))
+}
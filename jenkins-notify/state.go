@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// State remembers what has been announced so a restart does not repeat it
+type State struct {
+	Seen map[string]time.Time
+	path string
+}
+
+const stateMaxAge = 7 * 24 * time.Hour
+
+func LoadState(path string) (*State, error) {
+	state := &State{make(map[string]time.Time), path}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(state); err != nil {
+		return nil, err
+	}
+	if state.Seen == nil {
+		state.Seen = make(map[string]time.Time)
+	}
+	return state, nil
+}
+
+func (s *State) Announced(key string) bool {
+	_, ok := s.Seen[key]
+	return ok
+}
+
+func (s *State) Mark(key string, at time.Time) {
+	s.Seen[key] = at
+}
+
+func (s *State) Forget(key string) {
+	delete(s.Seen, key)
+}
+
+// Save writes to a temporary file first so a crash never leaves half a state
+func (s *State) Save() error {
+	for key, at := range s.Seen {
+		if time.Since(at) > stateMaxAge {
+			delete(s.Seen, key)
+		}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// limiter allows max notifications per window, denied counts the ones
+// suppressed since the last one that went through
+type limiter struct {
+	max    int
+	per    time.Duration
+	sent   []time.Time
+	denied int
+}
+
+func (l *limiter) Allow(now time.Time) bool {
+	if l.max <= 0 {
+		return true
+	}
+	var recent []time.Time
+	for _, at := range l.sent {
+		if now.Sub(at) < l.per {
+			recent = append(recent, at)
+		}
+	}
+	l.sent = recent
+	if len(l.sent) >= l.max {
+		l.denied++
+		return false
+	}
+	l.sent = append(l.sent, now)
+	return true
+}
+
+// Suppressed returns how many notifications were denied since it was last
+// called
+func (l *limiter) Suppressed() int {
+	denied := l.denied
+	l.denied = 0
+	return denied
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	start := time.Now()
+	l := &limiter{max: 2, per: time.Minute}
+	tests := []struct {
+		after    time.Duration
+		expected bool
+	}{
+		{0, true},
+		{10 * time.Second, true},
+		{20 * time.Second, false},
+		{30 * time.Second, false},
+		// the first one has left the window
+		{61 * time.Second, true},
+		{65 * time.Second, false},
+		{71 * time.Second, true},
+	}
+	for _, test := range tests {
+		if l.Allow(start.Add(test.after)) != test.expected {
+			t.Fatalf("Expected %v after %s", test.expected, test.after)
+		}
+	}
+	if suppressed := l.Suppressed(); suppressed != 3 {
+		t.Fatalf("Expected 3 suppressed but got %d", suppressed)
+	}
+	if suppressed := l.Suppressed(); suppressed != 0 {
+		t.Fatalf("Expected the count to reset but got %d", suppressed)
+	}
+	unlimited := &limiter{}
+	for i := 0; i < 100; i++ {
+		if !unlimited.Allow(start) {
+			t.Fatal("Expected no limit without max")
+		}
+	}
+}
+
+func TestStateSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if state.Announced("finished a job 1") {
+		t.Fatal("Did not expect a new state to have announced anything")
+	}
+	state.Mark("finished a job 1", time.Now())
+	state.Mark("offline a old", time.Now().Add(-stateMaxAge-time.Hour))
+	if err := state.Save(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("Expected the temporary file to be renamed but got %v", err)
+	}
+	loaded, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !loaded.Announced("finished a job 1") {
+		t.Fatal("Expected the mark to survive a restart")
+	}
+	if loaded.Announced("offline a old") {
+		t.Fatal("Expected marks older than a week to expire")
+	}
+	os.WriteFile(path, []byte("{"), 0600)
+	if _, err := LoadState(path); err == nil {
+		t.Fatal("Expected a broken state to fail")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/jwiklund/jenkins"
+	"io"
+	"os"
+	"time"
+)
+
+func writeStages(w io.Writer, stages []jenkins.Stage, logs bool) {
+	fmt.Fprintf(w, "%-30s %-14s %-30s %10s\n", "STAGE", "STATUS", "NODE", "DURATION")
+	for _, s := range stages {
+		node := s.Node
+		if node == "" {
+			node = "built-in"
+		}
+		duration := (time.Duration(s.Duration) * time.Millisecond).Truncate(time.Second).String()
+		fmt.Fprintf(w, "%-30s %-14s %-30s %10s\n", s.Name, s.Status, node, duration)
+		if logs {
+			fmt.Fprintln(w, "  "+s.LogUrl)
+		}
+	}
+}
+
+func main() {
+	logs := flag.Bool("logs", false, "Print the log url of each stage")
+	asJson := flag.Bool("json", false, "Print the stages as JSON")
+	profile := flag.String("profile", "", "Jenkins profile from ~/.jenkins (default $JENKINS_PROFILE or the first)")
+	flag.Parse()
+	if len(flag.Args()) != 1 {
+		fmt.Println("Specify one pipeline build, like job#123 or a build url")
+		os.Exit(1)
+	}
+	ref, err := jenkins.ParseBuildRef(flag.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	j, err := jenkins.NewFromProfile(*profile)
+	if err != nil {
+		fmt.Println("Could not configure jenkins: " + err.Error())
+		os.Exit(1)
+	}
+	stages, err := j.Stages(ref)
+	if err != nil {
+		fmt.Println("Could not get stages of " + ref.String() + ": " + err.Error())
+		os.Exit(1)
+	}
+	if *asJson {
+		type stage struct {
+			jenkins.Stage
+			Log string `json:"log"`
+		}
+		var out []stage
+		for _, s := range stages {
+			out = append(out, stage{s, s.LogUrl})
+		}
+		if err := json.NewEncoder(os.Stdout).Encode(out); err != nil {
+			fmt.Println(err.Error())
+		}
+		return
+	}
+	writeStages(os.Stdout, stages, *logs)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/jwiklund/jenkins"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	clear   = "\x1b[H\x1b[2J"
+	red     = "\x1b[31m"
+	bold    = "\x1b[1m"
+	reverse = "\x1b[7m"
+	reset   = "\x1b[0m"
+)
+
+type row struct {
+	node    string
+	offline bool
+	reason  string
+	build   string
+	url     string
+	started time.Time
+}
+
+type snapshot struct {
+	rows  []row
+	queue []jenkins.QueueItem
+	err   error
+	at    time.Time
+}
+
+var sorts = []string{"node", "build", "elapsed"}
+
+type top struct {
+	j        jenkins.Jenkins
+	fd       int
+	state    *term.State
+	snap     snapshot
+	filter   []string
+	sortBy   int
+	selected int
+	editing  bool
+	input    string
+	message  string
+}
+
+func fetch(j jenkins.Jenkins) snapshot {
+	computers, err := j.Computers()
+	if err != nil {
+		return snapshot{err: err, at: time.Now()}
+	}
+	queue, err := j.Queue()
+	if err != nil {
+		return snapshot{err: err, at: time.Now()}
+	}
+	var rows []row
+	for _, c := range computers {
+		if len(c.Executors) == 0 {
+			rows = append(rows, row{node: c.Name, offline: c.Offline, reason: c.OfflineReason})
+		}
+		for _, e := range c.Executors {
+			rows = append(rows, row{c.Name, c.Offline, c.OfflineReason, e.Build, e.Url, e.Started})
+		}
+	}
+	return snapshot{rows, queue, nil, time.Now()}
+}
+
+func (t *top) visible() []row {
+	var rows []row
+	for _, r := range t.snap.rows {
+		if jenkins.NameMatch(r.node, t.filter) || jenkins.NameMatch(r.build, t.filter) {
+			rows = append(rows, r)
+		}
+	}
+	sort.SliceStable(rows, func(a, b int) bool {
+		switch sorts[t.sortBy] {
+		case "build":
+			if (rows[a].build == "") != (rows[b].build == "") {
+				return rows[a].build != ""
+			}
+			return rows[a].build < rows[b].build
+		case "elapsed":
+			if rows[a].started.IsZero() != rows[b].started.IsZero() {
+				return !rows[a].started.IsZero()
+			}
+			return rows[a].started.Before(rows[b].started)
+		}
+		return rows[a].node < rows[b].node
+	})
+	return rows
+}
+
+func elapsed(started time.Time) string {
+	if started.IsZero() {
+		return ""
+	}
+	return time.Since(started).Truncate(time.Second).String()
+}
+
+// truncate cuts text to width runes so names are not cut mid character
+func truncate(text string, width int) string {
+	if utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	return string([]rune(text)[0:width])
+}
+
+// clamp keeps the selection within the rows
+func (t *top) clamp(rows []row) {
+	if t.selected >= len(rows) {
+		t.selected = len(rows) - 1
+	}
+	if t.selected < 0 {
+		t.selected = 0
+	}
+}
+
+func (t *top) render() {
+	width, height, err := term.GetSize(t.fd)
+	if err != nil {
+		width, height = 80, 24
+	}
+	fmt.Print(clear + strings.Join(t.screen(width, height), "\r\n"))
+}
+
+// screen lays out the lines of a width by height terminal
+func (t *top) screen(width, height int) []string {
+	var out []string
+	line := func(style, text string) {
+		text = truncate(text, width)
+		if style != "" {
+			text = style + text + reset
+		}
+		out = append(out, text)
+	}
+	rows := t.visible()
+	t.clamp(rows)
+	header := fmt.Sprintf("jenkins-top %s  sort:%s  filter:%s", t.snap.at.Format("15:04:05"), sorts[t.sortBy], strings.Join(t.filter, " "))
+	if t.editing {
+		header = "filter: " + t.input + "_"
+	}
+	line(bold, header)
+	if t.snap.err != nil {
+		line(red, "Could not fetch: "+t.snap.err.Error())
+	}
+	queueLines := len(t.snap.queue) + 2
+	if queueLines > height/3 {
+		queueLines = height / 3
+	}
+	space := height - len(out) - queueLines - 2
+	first := 0
+	if t.selected >= space && space > 0 {
+		first = t.selected - space + 1
+	}
+	line(bold, fmt.Sprintf("%-40s %-50s %s", "NODE", "BUILD", "ELAPSED"))
+	for i := first; i < len(rows) && i < first+space; i++ {
+		r := rows[i]
+		build := r.build
+		if r.offline {
+			build = "(offline) " + r.reason
+		} else if build == "" {
+			build = "Idle"
+		}
+		style := ""
+		if r.offline {
+			style = red
+		}
+		if i == t.selected {
+			style = style + reverse
+		}
+		line(style, fmt.Sprintf("%-40s %-50s %s", r.node, build, elapsed(r.started)))
+	}
+	line(bold, fmt.Sprintf("QUEUE (%d)", len(t.snap.queue)))
+	for i, q := range t.snap.queue {
+		if i >= queueLines-2 {
+			break
+		}
+		style := ""
+		if q.Blocked || q.Stuck {
+			style = red
+		}
+		line(style, fmt.Sprintf("%-40s %-10s %s", q.Job, elapsed(q.Since), q.Why))
+	}
+	for len(out) < height-1 {
+		out = append(out, "")
+	}
+	if t.message != "" {
+		line(red, t.message)
+	} else {
+		line("", "q quit  / filter  s sort  j/k move  c console  x ssh  r refresh")
+	}
+	return out
+}
+
+// external hands the terminal to a command such as a pager or ssh
+func (t *top) external(cmd *exec.Cmd) {
+	term.Restore(t.fd, t.state)
+	fmt.Print(clear)
+	if cmd.Stdin == nil {
+		cmd.Stdin = os.Stdin
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.message = "Command failed: " + err.Error()
+	}
+	if state, err := term.MakeRaw(t.fd); err == nil {
+		t.state = state
+	}
+}
+
+func (t *top) console(r row) {
+	if r.url == "" {
+		t.message = "Nothing building on " + r.node
+		return
+	}
+	ref, err := jenkins.ParseBuildRef(r.url)
+	if err != nil {
+		t.message = err.Error()
+		return
+	}
+	body, err := t.j.Console(ref)
+	if err != nil {
+		t.message = "Could not fetch console: " + err.Error()
+		return
+	}
+	defer body.Close()
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less"
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = body
+	t.external(cmd)
+}
+
+func (t *top) ssh(r row) {
+	info, err := t.j.NodeInfo(r.node)
+	if err != nil {
+		t.message = "Could not get info about " + r.node + ": " + err.Error()
+		return
+	}
+	t.external(exec.Command("ssh", info.Ip))
+}
+
+// key handles one key press and returns false when it is time to quit
+func (t *top) key(k string, refresh chan bool) bool {
+	t.message = ""
+	if t.editing {
+		switch k {
+		case "\r", "\n":
+			t.filter = strings.Fields(t.input)
+			t.editing = false
+		case "\x1b":
+			t.editing = false
+		case "\x7f", "\b":
+			if input := []rune(t.input); len(input) > 0 {
+				t.input = string(input[0 : len(input)-1])
+			}
+		default:
+			if r, size := utf8.DecodeRuneInString(k); size == len(k) && r != utf8.RuneError && unicode.IsPrint(r) {
+				t.input += k
+			}
+		}
+		return true
+	}
+	rows := t.visible()
+	switch k {
+	case "q", "\x03":
+		return false
+	case "/":
+		t.editing = true
+		t.input = strings.Join(t.filter, " ")
+	case "s":
+		t.sortBy = (t.sortBy + 1) % len(sorts)
+	case "j", "\x1b[B":
+		t.selected++
+		t.clamp(rows)
+	case "k", "\x1b[A":
+		t.selected--
+		t.clamp(rows)
+	case "r":
+		select {
+		case refresh <- true:
+		default:
+		}
+	case "c":
+		if t.selected < len(rows) {
+			t.console(rows[t.selected])
+		}
+	case "x":
+		if t.selected < len(rows) {
+			t.ssh(rows[t.selected])
+		}
+	}
+	return true
+}
+
+func readKeys(in io.Reader, keys chan string, next chan bool) {
+	buf := make([]byte, 16)
+	for {
+		n, err := in.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		keys <- string(buf[0:n])
+		// wait so an external command gets the terminal to itself
+		<-next
+	}
+}
+
+func main() {
+	interval := flag.Duration("interval", 3*time.Second, "Refresh interval")
+	flag.Parse()
+	j, err := jenkins.NewFromConfig()
+	if err != nil {
+		fmt.Println("Could not configure jenkins: " + err.Error())
+		return
+	}
+	fd := int(os.Stdin.Fd())
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		fmt.Println("Could not set up terminal: " + err.Error())
+		return
+	}
+	t := &top{j: j, fd: fd, state: state, filter: flag.Args()}
+	defer func() {
+		term.Restore(t.fd, t.state)
+		fmt.Print(clear)
+	}()
+
+	snapshots := make(chan snapshot)
+	refresh := make(chan bool, 1)
+	go func() {
+		for {
+			snapshots <- fetch(j)
+			select {
+			case <-refresh:
+			case <-time.After(*interval):
+			}
+		}
+	}()
+	keys := make(chan string)
+	next := make(chan bool)
+	go readKeys(os.Stdin, keys, next)
+
+	t.snap = <-snapshots
+	t.render()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case snap := <-snapshots:
+			t.snap = snap
+		case k, ok := <-keys:
+			if !ok || !t.key(k, refresh) {
+				return
+			}
+			next <- true
+		case <-ticker.C:
+		}
+		t.render()
+	}
+}
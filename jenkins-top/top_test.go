@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/jwiklund/jenkins"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func testTop() *top {
+	now := time.Now()
+	return &top{snap: snapshot{
+		rows: []row{
+			{node: "linux-2", build: "deploy #7", url: "http://x/job/deploy/7/", started: now.Add(-time.Minute)},
+			{node: "linux-1", build: "compile #455", url: "http://x/job/compile/455/", started: now.Add(-time.Hour)},
+			{node: "linux-1"},
+			{node: "windows-1", offline: true, reason: "disk full"},
+		},
+		queue: []jenkins.QueueItem{{Job: "docs", Why: "Waiting for next available executor", Blocked: true}},
+		at:    now,
+	}}
+}
+
+func nodes(rows []row) string {
+	var names []string
+	for _, r := range rows {
+		names = append(names, r.node+":"+r.build)
+	}
+	return strings.Join(names, " ")
+}
+
+func TestVisible(t *testing.T) {
+	for _, test := range []struct {
+		filter   []string
+		sortBy   int
+		expected string
+	}{
+		{nil, 0, "linux-1:compile #455 linux-1: linux-2:deploy #7 windows-1:"},
+		{nil, 1, "linux-1:compile #455 linux-2:deploy #7 linux-1: windows-1:"},
+		{nil, 2, "linux-1:compile #455 linux-2:deploy #7 linux-1: windows-1:"},
+		{[]string{"LINUX", "1"}, 0, "linux-1:compile #455 linux-1:"},
+		{[]string{"deploy"}, 0, "linux-2:deploy #7"},
+		{[]string{"mac"}, 0, ""},
+	} {
+		top := testTop()
+		top.filter = test.filter
+		top.sortBy = test.sortBy
+		if actual := nodes(top.visible()); actual != test.expected {
+			t.Fatalf("Expected %q with filter %v sorted by %s but got %q", test.expected, test.filter, sorts[test.sortBy], actual)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	top := testTop()
+	refresh := make(chan bool, 1)
+	for _, k := range []string{"/", "l", "i", "x", "\x7f", "n", "ü", "\x7f", "\x01", " ", "2", "\r"} {
+		if !top.key(k, refresh) {
+			t.Fatalf("Did not expect %q to quit", k)
+		}
+	}
+	if strings.Join(top.filter, " ") != "lin 2" || top.editing {
+		t.Fatalf("Expected the filter lin 2 but got %v", top.filter)
+	}
+	top.key("/", refresh)
+	top.key("w", refresh)
+	top.key("\x1b", refresh)
+	if strings.Join(top.filter, " ") != "lin 2" {
+		t.Fatalf("Expected escape to keep the filter but got %v", top.filter)
+	}
+	top.filter = nil
+	top.key("s", refresh)
+	top.key("s", refresh)
+	top.key("s", refresh)
+	if top.sortBy != 0 {
+		t.Fatalf("Expected the sort to cycle back to node but got %s", sorts[top.sortBy])
+	}
+	for i := 0; i < 10; i++ {
+		top.key("j", refresh)
+	}
+	if top.selected != 3 {
+		t.Fatalf("Expected the selection to stop at the last row but got %d", top.selected)
+	}
+	for i := 0; i < 10; i++ {
+		top.key("\x1b[A", refresh)
+	}
+	if top.selected != 0 {
+		t.Fatalf("Expected the selection to stop at the first row but got %d", top.selected)
+	}
+	top.key("j", refresh)
+	top.key("c", refresh)
+	if top.message != "Nothing building on linux-1" {
+		t.Fatalf("Expected no console for an idle node but got %q", top.message)
+	}
+	top.key("r", refresh)
+	top.key("r", refresh)
+	if len(refresh) != 1 {
+		t.Fatalf("Expected one pending refresh")
+	}
+	if top.key("q", refresh) {
+		t.Fatalf("Expected q to quit")
+	}
+}
+
+func TestScreen(t *testing.T) {
+	top := testTop()
+	top.snap.rows[0].node = "bygg-ärende-överst-åäö-nod"
+	top.selected = 10
+	lines := top.screen(30, 12)
+	if len(lines) != 12 {
+		t.Fatalf("Expected 12 lines but got %d", len(lines))
+	}
+	if top.selected != 3 {
+		t.Fatalf("Expected the selection to be clamped to the last row but got %d", top.selected)
+	}
+	for _, line := range lines {
+		text := line
+		for _, code := range []string{bold, red, reverse, reset} {
+			text = strings.Replace(text, code, "", -1)
+		}
+		if !utf8.ValidString(line) || utf8.RuneCountInString(text) > 30 {
+			t.Fatalf("Expected lines of at most 30 whole characters but got %q", line)
+		}
+	}
+	if lines[2] != "bygg-ärende-överst-åäö-nod    " {
+		t.Fatalf("Expected the first row to be cut at 30 characters but got %q", lines[2])
+	}
+	if lines[5] != red+reverse+"windows-1                     "+reset {
+		t.Fatalf("Expected the selected offline node in red but got %q", lines[5])
+	}
+	if !strings.HasPrefix(lines[6], bold+"QUEUE (1)") || !strings.HasPrefix(lines[7], red+"docs") {
+		t.Fatalf("Expected the blocked queue item below the nodes but got %q", lines[6:8])
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/jwiklund/jenkins"
+	"os"
+	"sync"
+	"time"
+)
+
+func exitCode(result string) int {
+	switch result {
+	case "SUCCESS":
+		return 0
+	case "FAILURE":
+		return 1
+	case "UNSTABLE", "ABORTED", "NOT_BUILT":
+		return 2
+	}
+	return 3
+}
+
+// severity orders exit codes from best to worst, a failed build is worse
+// than an unstable one and not knowing the result is worst
+var severity = map[int]int{0: 0, 2: 1, 1: 2, 3: 3}
+
+func worst(codes []int) int {
+	worst := 0
+	for _, code := range codes {
+		if severity[code] > severity[worst] {
+			worst = code
+		}
+	}
+	return worst
+}
+
+func wait(ctx context.Context, j jenkins.Jenkins, arg string, interval time.Duration) int {
+	ref, err := jenkins.ParseBuildRef(arg)
+	if err != nil {
+		fmt.Println(err.Error())
+		return 3
+	}
+	info, err := j.WaitForBuildEvery(ctx, ref, interval)
+	if err != nil {
+		fmt.Println("Could not wait for " + arg + ": " + err.Error())
+		return 3
+	}
+	ref.Number = info.Number
+	fmt.Printf("%s %s in %s\n", ref.String(), info.Result, time.Duration(info.Duration)*time.Millisecond)
+	return exitCode(info.Result)
+}
+
+func main() {
+	timeout := flag.Duration("timeout", 0, "Give up after this long (0 waits forever)")
+	interval := flag.Duration("interval", jenkins.DefaultWaitInterval, "Initial poll interval")
+	all := flag.Bool("all", false, "Wait for all builds given, exit with the worst result")
+	flag.Parse()
+	if *interval <= 0 {
+		fmt.Println("The poll interval must be positive")
+		os.Exit(3)
+	}
+	if len(flag.Args()) == 0 || (len(flag.Args()) > 1 && !*all) {
+		fmt.Println("Specify one build to wait for (or several with -all)")
+		os.Exit(3)
+	}
+	j, err := jenkins.NewFromConfig()
+	if err != nil {
+		fmt.Println("Could not configure jenkins: " + err.Error())
+		os.Exit(3)
+	}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	codes := make([]int, len(flag.Args()))
+	var wg sync.WaitGroup
+	for i, arg := range flag.Args() {
+		wg.Add(1)
+		go func(i int, arg string) {
+			defer wg.Done()
+			codes[i] = wait(ctx, j, arg, *interval)
+		}(i, arg)
+	}
+	wg.Wait()
+	os.Exit(worst(codes))
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestWorst(t *testing.T) {
+	tests := []struct {
+		results  []string
+		expected int
+	}{
+		{[]string{"SUCCESS", "SUCCESS"}, 0},
+		{[]string{"SUCCESS", "UNSTABLE"}, 2},
+		{[]string{"UNSTABLE", "FAILURE"}, 1},
+		{[]string{"FAILURE", "ABORTED", "SUCCESS"}, 1},
+		{[]string{"FAILURE", ""}, 3},
+	}
+	for _, test := range tests {
+		var codes []int
+		for _, result := range test.results {
+			codes = append(codes, exitCode(result))
+		}
+		if code := worst(codes); code != test.expected {
+			t.Fatalf("Expected %d for %v but got %d", test.expected, test.results, code)
+		}
+	}
+}
@@ -1,35 +1,72 @@
 package jenkins
 
 import (
-	"bufio"
+	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Jenkins interface {
+	Server() string
 	Builds() ([]Build, error)
 	NodeInfo(node string) (NodeInfo, error)
 	Jobs() ([]Job, error)
 	JobInfo(job string) (JobInfo, error)
+	JobBuilds(job string) ([]BuildInfo, error)
+	JobBuildsRange(job string, from, to int) ([]BuildInfo, error)
+	Resolve(ref BuildRef) (BuildRef, error)
+	BuildInfo(ref BuildRef) (BuildInfo, error)
+	BuildGraph(ref BuildRef) (BuildNode, error)
+	Stages(ref BuildRef) ([]Stage, error)
+	WaitForBuild(ctx context.Context, ref BuildRef) (BuildInfo, error)
+	WaitForBuildEvery(ctx context.Context, ref BuildRef, interval time.Duration) (BuildInfo, error)
+	Computers() ([]Computer, error)
+	Queue() ([]QueueItem, error)
+	Console(ref BuildRef) (io.ReadCloser, error)
+	Watch(ctx context.Context, interval time.Duration) <-chan ExecutorEvent
 }
 
 type Build struct {
 	Node string
 	// node_url string always /computer/$name
-	// state can be offline
-	Build string
+	Build   string
+	Number  int
+	Offline bool
+	// Url of the build, the display name does not tell which folder it is in
+	Url string
 }
 
 func (b Build) String() string {
+	if b.Offline {
+		return b.Node + " (offline)"
+	}
 	if b.Build == "" {
 		return b.Node
 	}
+	if b.Number > 0 {
+		return b.Node + " building " + b.Build + " #" + strconv.Itoa(b.Number)
+	}
 	return b.Node + " building " + b.Build
 }
 
+// NameMatch tells if name contains every part of match ignoring case, it
+// is how the commands filter nodes and builds by their arguments
+func NameMatch(name string, match []string) bool {
+	for _, part := range match {
+		if !strings.Contains(strings.ToLower(name), strings.ToLower(part)) {
+			return false
+		}
+	}
+	return true
+}
+
 type NodeInfo struct {
 	Node string
 	Ip   string
@@ -38,15 +75,67 @@ type NodeInfo struct {
 type Job struct {
 	Name  string `json:"name"`
 	Color string `json:"color"`
+	Url   string `json:"url"`
 }
 
 type JobInfo map[string]string
 
+type BuildInfo struct {
+	Job               string
+	Class             string        `json:"_class"`
+	Number            int           `json:"number"`
+	Url               string        `json:"url"`
+	Result            string        `json:"result"`
+	Building          bool          `json:"building"`
+	Timestamp         int64         `json:"timestamp"`
+	Duration          int64         `json:"duration"`
+	EstimatedDuration int64         `json:"estimatedDuration"`
+	BuiltOn           string        `json:"builtOn"`
+	FullDisplayName   string        `json:"fullDisplayName"`
+	Actions           []BuildAction `json:"actions"`
+	// ChangeSets is filled for pipelines and ChangeSet for freestyle jobs
+	ChangeSets []ChangeSet `json:"changeSets"`
+	ChangeSet  ChangeSet   `json:"changeSet"`
+	Culprits   []User      `json:"culprits"`
+}
+
+type BuildAction struct {
+	FailCount  int              `json:"failCount"`
+	TotalCount int              `json:"totalCount"`
+	Causes     []BuildCause     `json:"causes"`
+	Parameters []BuildParameter `json:"parameters"`
+	// TriggeredBuilds are the downstream builds started by the
+	// parameterized trigger plugin
+	TriggeredBuilds []struct {
+		Url string `json:"url"`
+	} `json:"triggeredBuilds"`
+}
+
+const buildInfoTree = "_class,number,url,result,building,timestamp,duration,estimatedDuration,builtOn,fullDisplayName," +
+	"actions[failCount,totalCount,causes[_class,shortDescription,upstreamProject,upstreamBuild,userId],parameters[name,value],triggeredBuilds[url]]," +
+	"changeSets[items[commitId,msg,author[fullName]]],changeSet[items[commitId,msg,author[fullName]]],culprits[fullName]"
+
+// Tests returns failed and total test counts, or -1, -1 without test results
+func (b BuildInfo) Tests() (int, int) {
+	for _, a := range b.Actions {
+		if a.TotalCount > 0 {
+			return a.FailCount, a.TotalCount
+		}
+	}
+	return -1, -1
+}
+
 func New(url string) Jenkins {
 	return jenkins(url)
 }
 
 func NewFromConfig() (Jenkins, error) {
+	return NewFromProfile("")
+}
+
+// NewFromProfile picks a server from ~/.jenkins, the default profile is
+// taken from $JENKINS_PROFILE or else the first line in the file.
+func NewFromProfile(name string) (Jenkins, error) {
 	home := os.Getenv("HOME")
 	if home == "" {
 		return nil, errors.New("HOME not set")
@@ -56,12 +145,28 @@ func NewFromConfig() (Jenkins, error) {
 		return nil, err
 	}
 	defer f.Close()
-	buf := bufio.NewReader(f)
-	l, err := buf.ReadString('\n')
+	profiles, err := parseProfiles(f)
 	if err != nil {
 		return nil, err
 	}
-	return jenkins(string(strings.TrimSpace(l))), nil
+	if name == "" {
+		name = os.Getenv("JENKINS_PROFILE")
+	}
+	profile, err := findProfile(profiles, name)
+	if err != nil {
+		return nil, err
+	}
+	if profile.Timeout > 0 {
+		SetTimeout(profile.Timeout)
+	}
+	return jenkins(profile.Url), nil
+}
+
+var httpClient = &http.Client{}
+
+// SetTimeout limits how long any request to jenkins may take
+func SetTimeout(timeout time.Duration) {
+	httpClient.Timeout = timeout
 }
 
 type jenkins string
@@ -98,13 +203,33 @@ func (j jenkins) auth() (string, string, error) {
 	return auth[0:ind], auth[ind+1:], nil
 }
 
+func (j jenkins) Server() string {
+	return j.url()
+}
+
 func (j jenkins) Builds() ([]Build, error) {
-	resp, err := http.Get(j.url() + "/ajaxExecutors")
+	body, err := j.get(j.url() + "/ajaxExecutors")
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	return parseExecutors(resp.Body)
+	defer body.Close()
+	builds, err := parseExecutors(body)
+	if err != nil {
+		return nil, err
+	}
+	base, err := url.Parse(j.url() + "/")
+	if err != nil {
+		return nil, err
+	}
+	for i, b := range builds {
+		if b.Url == "" {
+			continue
+		}
+		if link, err := url.Parse(b.Url); err == nil {
+			builds[i].Url = base.ResolveReference(link).String()
+		}
+	}
+	return builds, nil
 }
 
 func (j jenkins) authGet(url string) (io.ReadCloser, error) {
@@ -117,14 +242,58 @@ func (j jenkins) authGet(url string) (io.ReadCloser, error) {
 		return nil, err
 	}
 	req.SetBasicAuth(user, pass)
-	client := http.Client{}
-	resp, err := client.Do(req)
+	limiter.wait()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+type notFound string
+
+func (n notFound) Error() string {
+	return "Not found " + string(n)
+}
+
+// get sends credentials when they are configured but, unlike authGet,
+// does not require them.
+func (j jenkins) get(url string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if user, pass, err := j.auth(); err == nil {
+		req.SetBasicAuth(user, pass)
+	}
+	limiter.wait()
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, notFound(url)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New("Got " + resp.Status + " from " + url)
+	}
 	return resp.Body, nil
 }
 
+func (j jenkins) getJson(url string, target interface{}) error {
+	body, err := j.get(url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(target); err != nil {
+		return errors.New("Could not parse json from " + url + ": " + err.Error())
+	}
+	return nil
+}
+
 func (j jenkins) NodeInfo(node string) (NodeInfo, error) {
 	body, err := j.authGet(j.url() + "/computer/" + node + "/logText/progressiveHtml")
 	if err != nil {
@@ -135,12 +304,43 @@ func (j jenkins) NodeInfo(node string) (NodeInfo, error) {
 }
 
 func (j jenkins) Jobs() ([]Job, error) {
-	resp, err := http.Get(j.url() + "/api/json?tree=jobs[name,color]")
+	body, err := j.get(j.url() + "/api/json?tree=jobs[name,color,url]")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return parseJobs(body)
+}
+
+func (j jenkins) JobBuilds(job string) ([]BuildInfo, error) {
+	var builds struct {
+		Builds []BuildInfo `json:"builds"`
+	}
+	err := j.getJson(jobUrl(j.url(), job)+"/api/json?tree=builds["+buildInfoTree+"]", &builds)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	return parseJobs(resp.Body)
+	for i := range builds.Builds {
+		builds.Builds[i].Job = job
+	}
+	return builds.Builds, nil
+}
+
+// JobBuildsRange returns builds newest first, from and to are positions in
+// that list (to is exclusive) so older builds can be fetched a page at a time
+func (j jenkins) JobBuildsRange(job string, from, to int) ([]BuildInfo, error) {
+	var builds struct {
+		Builds []BuildInfo `json:"allBuilds"`
+	}
+	err := j.getJson(jobUrl(j.url(), job)+"/api/json?tree=allBuilds["+buildInfoTree+"]{"+
+		strconv.Itoa(from)+","+strconv.Itoa(to)+"}", &builds)
+	if err != nil {
+		return nil, err
+	}
+	for i := range builds.Builds {
+		builds.Builds[i].Job = job
+	}
+	return builds.Builds, nil
 }
 
 func (j jenkins) JobInfo(job string) (JobInfo, error) {
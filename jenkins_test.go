@@ -1,9 +1,24 @@
 package jenkins
 
 import (
+	"strings"
 	"testing"
 )
 
+func TestNameMatch(t *testing.T) {
+	for match, expected := range map[string]bool{
+		"":             true,
+		"NODE":         true,
+		"node7 linux":  true,
+		"node7 window": false,
+		"node8":        false,
+	} {
+		if NameMatch("linux-node7", strings.Fields(match)) != expected {
+			t.Fatalf("Expected %q to match %v", match, expected)
+		}
+	}
+}
+
 func TestJenkinsUrl(t *testing.T) {
 	j := jenkins("localhost/jenkins")
 	t.Logf("Jenkins.url() %s", j.url())
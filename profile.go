@@ -0,0 +1,74 @@
+package jenkins
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Profile is one server in ~/.jenkins. A line is either just the url (the
+// original format) or a profile name followed by the url, optionally
+// followed by options such as timeout=30s.
+type Profile struct {
+	Name    string
+	Url     string
+	Timeout time.Duration
+}
+
+func parseProfiles(rdr io.Reader) ([]Profile, error) {
+	var profiles []Profile
+	scanner := bufio.NewScanner(rdr)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var profile Profile
+		if len(fields) == 1 || strings.Contains(fields[1], "=") {
+			profile.Url = fields[0]
+			fields = fields[1:]
+		} else {
+			profile.Name = fields[0]
+			profile.Url = fields[1]
+			fields = fields[2:]
+		}
+		for _, option := range fields {
+			ind := strings.Index(option, "=")
+			if ind == -1 {
+				return nil, errors.New("Invalid option " + option + " for " + profile.Url)
+			}
+			switch option[0:ind] {
+			case "timeout":
+				timeout, err := time.ParseDuration(option[ind+1:])
+				if err != nil {
+					return nil, errors.New("Invalid timeout for " + profile.Url + ": " + err.Error())
+				}
+				profile.Timeout = timeout
+			default:
+				return nil, errors.New("Unknown option " + option + " for " + profile.Url)
+			}
+		}
+		profiles = append(profiles, profile)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+func findProfile(profiles []Profile, name string) (Profile, error) {
+	if len(profiles) == 0 {
+		return Profile{}, errors.New("No jenkins configured")
+	}
+	if name == "" {
+		return profiles[0], nil
+	}
+	for _, profile := range profiles {
+		if profile.Name == name {
+			return profile, nil
+		}
+	}
+	return Profile{}, errors.New("Profile " + name + " not configured")
+}
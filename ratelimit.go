@@ -0,0 +1,45 @@
+package jenkins
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter spaces requests evenly, every request reserves the next free
+// slot and sleeps until it comes
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+var limiter = &rateLimiter{}
+
+// SetRateLimit caps the number of requests per second sent to jenkins by
+// everything in this process, zero removes the limit
+func SetRateLimit(perSecond float64) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if perSecond <= 0 {
+		limiter.interval = 0
+	} else {
+		limiter.interval = time.Duration(float64(time.Second) / perSecond)
+	}
+	limiter.next = time.Time{}
+}
+
+func (l *rateLimiter) wait() {
+	l.mu.Lock()
+	if l.interval == 0 {
+		l.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
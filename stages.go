@@ -0,0 +1,47 @@
+package jenkins
+
+import (
+	"strconv"
+)
+
+// Stage is a stage of a pipeline build as the pipeline stage view plugin
+// (/wfapi/) describes it. Node is empty for stages on the built-in node and
+// LogUrl points at the wfapi log of the stage.
+type Stage struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Node     string `json:"execNode"`
+	Start    int64  `json:"startTimeMillis"`
+	Duration int64  `json:"durationMillis"`
+	LogUrl   string `json:"-"`
+}
+
+// workflowRun is the class of pipeline builds, the only ones with stages
+const workflowRun = "org.jenkinsci.plugins.workflow.job.WorkflowRun"
+
+// Pipeline tells if the build is a pipeline build with stages
+func (b BuildInfo) Pipeline() bool {
+	return b.Class == workflowRun
+}
+
+// Stages returns the stages of a pipeline build in order, other kinds of
+// builds have no /wfapi/ and give an error
+func (j jenkins) Stages(ref BuildRef) ([]Stage, error) {
+	ref, err := j.Resolve(ref)
+	if err != nil {
+		return nil, err
+	}
+	s := j.server(ref)
+	build := jobUrl(s.url(), ref.Job) + "/" + strconv.Itoa(ref.Number)
+	var describe struct {
+		Stages []Stage `json:"stages"`
+	}
+	if err := s.getJson(build+"/wfapi/describe", &describe); err != nil {
+		return nil, err
+	}
+	for i := range describe.Stages {
+		describe.Stages[i].LogUrl = build + "/execution/node/" + describe.Stages[i].Id + "/wfapi/log"
+	}
+	return describe.Stages, nil
+}
@@ -0,0 +1,44 @@
+package jenkins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestStages(t *testing.T) {
+	describe, err := os.ReadFile("stages_test.json")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/job/folder/job/pipeline/12/wfapi/describe" {
+			w.Write(describe)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	stages, err := jenkins(server.URL).Stages(BuildRef{Job: "folder/pipeline", Number: 12})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(stages) != 3 {
+		t.Fatalf("Expected 3 stages but got %+v", stages)
+	}
+	tests := stages[1]
+	if tests.Name != "Integration tests" || tests.Status != "FAILED" || tests.Node != "euca-jdk-1-6-linux-2-6-113" ||
+		tests.Start != 1381912465200 || tests.Duration != 379000 {
+		t.Fatalf("Unexpected stage %+v", tests)
+	}
+	if tests.LogUrl != server.URL+"/job/folder/job/pipeline/12/execution/node/17/wfapi/log" {
+		t.Fatalf("Unexpected log url %s", tests.LogUrl)
+	}
+	if stages[0].Node != "" || stages[2].Status != "NOT_EXECUTED" {
+		t.Fatalf("Unexpected stages %+v", stages)
+	}
+	if _, err := jenkins(server.URL).Stages(BuildRef{Job: "freestyle", Number: 1}); err == nil {
+		t.Fatal("Expected builds without stages to fail")
+	}
+}
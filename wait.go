@@ -0,0 +1,45 @@
+package jenkins
+
+import (
+	"context"
+	"time"
+)
+
+const DefaultWaitInterval = 5 * time.Second
+
+func (j jenkins) WaitForBuild(ctx context.Context, ref BuildRef) (BuildInfo, error) {
+	return j.WaitForBuildEvery(ctx, ref, DefaultWaitInterval)
+}
+
+// WaitForBuildEvery polls the build until it is no longer building. The
+// delay between polls starts at interval and backs off to at most eight
+// times that. A build number that does not exist yet is treated as still
+// queued. An interval that is not positive means DefaultWaitInterval.
+func (j jenkins) WaitForBuildEvery(ctx context.Context, ref BuildRef, interval time.Duration) (BuildInfo, error) {
+	if interval <= 0 {
+		interval = DefaultWaitInterval
+	}
+	ref, err := j.Resolve(ref)
+	if err != nil {
+		return BuildInfo{}, err
+	}
+	delay := interval
+	for {
+		info, err := j.BuildInfo(ref)
+		if err == nil && !info.Building && info.Result != "" {
+			return info, nil
+		}
+		if _, queued := err.(notFound); err != nil && !queued {
+			return BuildInfo{}, err
+		}
+		select {
+		case <-ctx.Done():
+			return info, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay = delay * 3 / 2
+		if delay > 8*interval {
+			delay = 8 * interval
+		}
+	}
+}
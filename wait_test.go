@@ -0,0 +1,50 @@
+package jenkins
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWaitForBuild(t *testing.T) {
+	polls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/job/folder/job/job/lastBuild/api/json":
+			w.Write([]byte(`{"number":12}`))
+		case "/job/folder/job/job/12/api/json":
+			polls++
+			if polls < 3 {
+				w.Write([]byte(`{"number":12,"building":true}`))
+			} else {
+				w.Write([]byte(`{"number":12,"building":false,"result":"UNSTABLE","duration":1500}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	j := jenkins(server.URL)
+	info, err := j.WaitForBuildEvery(context.Background(), BuildRef{Job: "folder/job"}, time.Millisecond)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if info.Number != 12 || info.Result != "UNSTABLE" || info.Duration != 1500 || polls != 3 {
+		t.Fatalf("Unexpected %+v after %d polls", info, polls)
+	}
+}
+
+func TestWaitForBuildTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := jenkins(server.URL).WaitForBuildEvery(ctx, BuildRef{Job: "job", Number: 3}, time.Millisecond)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected deadline exceeded but got %v", err)
+	}
+}
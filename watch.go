@@ -0,0 +1,155 @@
+package jenkins
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	BuildStarted  = "started"
+	BuildFinished = "finished"
+	NodeOffline   = "offline"
+	NodeOnline    = "online"
+	WatchError    = "error"
+)
+
+type ExecutorEvent struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Node   string    `json:"node"`
+	Build  string    `json:"build,omitempty"`
+	Number int       `json:"number,omitempty"`
+	Url    string    `json:"url,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+func (e ExecutorEvent) String() string {
+	str := e.Time.Format(time.RFC3339) + " " + e.Type + " " + e.Node
+	if e.Build != "" {
+		str = str + " " + e.Build
+		if e.Number > 0 {
+			str = str + " #" + strconv.Itoa(e.Number)
+		}
+	}
+	if e.Error != "" {
+		str = str + " " + e.Error
+	}
+	return str
+}
+
+var eventOrder = map[string]int{BuildFinished: 0, NodeOffline: 1, NodeOnline: 2, BuildStarted: 3}
+
+type nodeState struct {
+	offline bool
+	builds  map[Build]int
+}
+
+func nodeStates(builds []Build) map[string]nodeState {
+	states := make(map[string]nodeState)
+	for _, b := range builds {
+		state, ok := states[b.Node]
+		if !ok {
+			state = nodeState{b.Offline, make(map[Build]int)}
+		}
+		if b.Build != "" {
+			state.builds[Build{Build: b.Build, Number: b.Number, Url: b.Url}]++
+		}
+		states[b.Node] = state
+	}
+	return states
+}
+
+func buildEvents(at time.Time, kind, node string, from, to map[Build]int) []ExecutorEvent {
+	var events []ExecutorEvent
+	for build, count := range from {
+		for i := to[build]; i < count; i++ {
+			events = append(events, ExecutorEvent{Time: at, Type: kind, Node: node, Build: build.Build, Number: build.Number, Url: build.Url})
+		}
+	}
+	return events
+}
+
+// diffBuilds reports what changed between two executor snapshots. A node
+// that disappears is reported as offline and one that shows up as online.
+func diffBuilds(at time.Time, before, after []Build) []ExecutorEvent {
+	old := nodeStates(before)
+	cur := nodeStates(after)
+	var events []ExecutorEvent
+	for node, state := range old {
+		next, ok := cur[node]
+		if !ok {
+			next = nodeState{true, nil}
+		}
+		events = append(events, buildEvents(at, BuildFinished, node, state.builds, next.builds)...)
+		if !state.offline && next.offline {
+			events = append(events, ExecutorEvent{Time: at, Type: NodeOffline, Node: node})
+		}
+	}
+	for node, state := range cur {
+		prev, ok := old[node]
+		if !ok {
+			prev = nodeState{true, nil}
+		}
+		if prev.offline && !state.offline {
+			events = append(events, ExecutorEvent{Time: at, Type: NodeOnline, Node: node})
+		}
+		events = append(events, buildEvents(at, BuildStarted, node, state.builds, prev.builds)...)
+	}
+	sort.Slice(events, func(a, b int) bool {
+		if events[a].Node != events[b].Node {
+			return events[a].Node < events[b].Node
+		}
+		if eventOrder[events[a].Type] != eventOrder[events[b].Type] {
+			return eventOrder[events[a].Type] < eventOrder[events[b].Type]
+		}
+		if events[a].Build != events[b].Build {
+			return events[a].Build < events[b].Build
+		}
+		return events[a].Number < events[b].Number
+	})
+	return events
+}
+
+func watch(ctx context.Context, interval time.Duration, builds func() ([]Build, error)) <-chan ExecutorEvent {
+	events := make(chan ExecutorEvent)
+	go func() {
+		defer close(events)
+		var last []Build
+		first := true
+		for {
+			current, err := builds()
+			now := time.Now()
+			var batch []ExecutorEvent
+			if err != nil {
+				batch = []ExecutorEvent{{Time: now, Type: WatchError, Error: err.Error()}}
+			} else {
+				if !first {
+					batch = diffBuilds(now, last, current)
+				}
+				last = current
+				first = false
+			}
+			for _, event := range batch {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return events
+}
+
+// Watch polls Builds every interval and sends what changed since the
+// previous poll. The channel is closed when ctx is done.
+func (j jenkins) Watch(ctx context.Context, interval time.Duration) <-chan ExecutorEvent {
+	return watch(ctx, interval, j.Builds)
+}
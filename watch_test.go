@@ -0,0 +1,70 @@
+package jenkins
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func checkEvent(t *testing.T, kind, node, build string, actual ExecutorEvent) {
+	if actual.Type != kind || actual.Node != node || actual.Build != build {
+		t.Fatalf("Expected %s %s %s but got %s", kind, node, build, actual.String())
+	}
+}
+
+func TestDiffBuilds(t *testing.T) {
+	before := []Build{
+		{Node: "a", Build: "job1", Number: 1},
+		{Node: "a", Build: ""},
+		{Node: "b", Build: "job2", Number: 7, Url: "http://x/job/job2/7/"},
+		{Node: "c", Offline: true},
+	}
+	after := []Build{
+		{Node: "a", Build: "job1", Number: 1},
+		{Node: "a", Build: "job1", Number: 2},
+		{Node: "b", Offline: true},
+		{Node: "c", Build: ""},
+	}
+	events := diffBuilds(time.Now(), before, after)
+	if len(events) != 4 {
+		t.Fatalf("Expected 4 events but got %v", events)
+	}
+	checkEvent(t, BuildStarted, "a", "job1", events[0])
+	if events[0].Number != 2 {
+		t.Fatalf("Expected build 2 to start but got %s", events[0].String())
+	}
+	checkEvent(t, BuildFinished, "b", "job2", events[1])
+	if events[1].Url != "http://x/job/job2/7/" {
+		t.Fatalf("Expected the url of build 7 but got %q", events[1].Url)
+	}
+	checkEvent(t, NodeOffline, "b", "", events[2])
+	checkEvent(t, NodeOnline, "c", "", events[3])
+}
+
+func TestDiffBuildsUnchanged(t *testing.T) {
+	builds := []Build{{Node: "a", Build: "job1", Number: 1}, {Node: "b", Offline: true}}
+	if events := diffBuilds(time.Now(), builds, builds); len(events) != 0 {
+		t.Fatalf("Expected no events but got %v", events)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	snapshots := [][]Build{
+		{{Node: "a"}},
+		{{Node: "a", Build: "job1", Number: 1}},
+	}
+	polls := 0
+	builds := func() ([]Build, error) {
+		snapshot := snapshots[polls%len(snapshots)]
+		polls++
+		return snapshot, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := watch(ctx, time.Millisecond, builds)
+	checkEvent(t, BuildStarted, "a", "job1", <-events)
+	checkEvent(t, BuildFinished, "a", "job1", <-events)
+	cancel()
+	for range events {
+	}
+}